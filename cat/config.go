@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	flag "github.com/spf13/pflag"
+)
+
+// $XDG_CONFIG_HOME/gills, or ~/.config/gills
+func configDir() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "gills")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "gills")
+}
+
+func configPath() string {
+	dir := configDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "cat.conf")
+}
+
+// the config file flags were read from, "" when there wasnt one
+var configLoaded string
+
+// the command line with the config files flags in front, so anything given
+// on the command line wins. --no-config anywhere before a -- skips the file
+func withConfigArgs(args []string) []string {
+	for _, a := range args {
+		if a == "--" {
+			break
+		}
+		if a == "--no-config" {
+			return args
+		}
+	}
+
+	path := configPath()
+	f, err := os.Open(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "cant open config %s: %v\n", path, err)
+		}
+		return args
+	}
+	defer f.Close()
+	configLoaded = path
+
+	var conf []string
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words, err := splitWords(line)
+		if err == nil {
+			err = checkConfigFlag(words[0])
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s:%d: %v\n", path, n, err)
+			os.Exit(1)
+		}
+		conf = append(conf, words...)
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "cant read config %s: %v\n", path, err)
+	}
+	return append(conf, args...)
+}
+
+// config lines are flags only, and only ones cat knows. checked here so the
+// error points at the config instead of looking like a bad command line
+func checkConfigFlag(arg string) error {
+	name, isLong := strings.CutPrefix(arg, "--")
+	if isLong {
+		name, _, _ = strings.Cut(name, "=")
+		if flag.Lookup(name) == nil {
+			return fmt.Errorf("unknown flag --%s", name)
+		}
+		return nil
+	}
+	if len(arg) < 2 || arg[0] != '-' {
+		return fmt.Errorf("expected a flag, got %q", arg)
+	}
+	if flag.ShorthandLookup(arg[1:2]) == nil {
+		return fmt.Errorf("unknown flag -%s", arg[1:2])
+	}
+	return nil
+}
+
+// splits on spaces, keeping anything in single or double quotes together
+func splitWords(line string) ([]string, error) {
+	var words []string
+	var cur strings.Builder
+	inWord := false
+	var quote rune
+	for _, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		default:
+			cur.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inWord {
+		words = append(words, cur.String())
+	}
+	return words, nil
+}
+
+// names of themes and languages loaded from the config directory, to tell
+// them apart in the --list-* output
+var (
+	userThemes    = map[string]bool{}
+	userLanguages = map[string]bool{}
+)
+
+// registers chroma XML styles from <config>/cat/themes and XML lexers from
+// <config>/cat/syntaxes. ones named like a built-in replace it
+func loadUserDefinitions() {
+	dir := configDir()
+	if dir == "" {
+		return
+	}
+
+	themes := filepath.Join(dir, "cat", "themes")
+	for _, name := range xmlFiles(themes) {
+		f, err := os.Open(filepath.Join(themes, name))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cant open theme %s: %v\n", name, err)
+			continue
+		}
+		style, err := chroma.NewXMLStyle(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cant load theme %s: %v\n", name, err)
+			continue
+		}
+		styles.Register(style)
+		userThemes[style.Name] = true
+	}
+
+	syntaxes := filepath.Join(dir, "cat", "syntaxes")
+	for _, name := range xmlFiles(syntaxes) {
+		lexer, err := chroma.NewXMLLexer(os.DirFS(syntaxes), name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cant load syntax %s: %v\n", name, err)
+			continue
+		}
+		lexers.Register(lexer)
+		userLanguages[lexer.Config().Name] = true
+	}
+}
+
+func xmlFiles(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "cant read %s: %v\n", dir, err)
+		}
+		return nil
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.EqualFold(filepath.Ext(e.Name()), ".xml") {
+			names = append(names, e.Name())
+		}
+	}
+	return names
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// picks a decompressor by the first bytes of the input. zlibs two byte
+// header is easy to hit in plain text ("x^" is one), so it's only trusted
+// with a .zlib or .zz name, or with -z
+func compression(magic []byte, name string) string {
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return "gzip"
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return "zstd"
+	case len(magic) >= 4 && string(magic[:3]) == "BZh" && magic[3] >= '1' && magic[3] <= '9':
+		return "bzip2"
+	case len(magic) >= 2 && magic[0]&0x0f == 8 && magic[0]>>4 <= 7 && (int(magic[0])<<8|int(magic[1]))%31 == 0:
+		ext := strings.ToLower(filepath.Ext(name))
+		if flagDecompress || ext == ".zlib" || ext == ".zz" {
+			return "zlib"
+		}
+	}
+	return ""
+}
+
+// whether decompress would unwrap the file at name, for those that need
+// to know before they read it
+func compressedFile(name string) bool {
+	f, err := os.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, 4)
+	n, _ := f.ReadAt(magic, 0)
+	return compression(magic[:n], name) != ""
+}
+
+// wraps r in a decompressor when it looks compressed. regular files are
+// peeked at with ReadAt so an uncompressed one is passed on as the *os.File
+// it was, anything else goes through a bufio.Reader. the returned close
+// func is always safe to call
+func decompress(name string, r io.Reader) (io.Reader, func(), error) {
+	nop := func() {}
+	magic := make([]byte, 4)
+	if f, ok := r.(*os.File); ok {
+		if st, err := f.Stat(); err == nil && st.Mode().IsRegular() {
+			n, _ := f.ReadAt(magic, 0)
+			if compression(magic[:n], name) == "" {
+				return f, nop, nil
+			}
+		}
+	}
+
+	br := bufio.NewReader(r)
+	magic, _ = br.Peek(4)
+	switch compression(magic, name) {
+	case "gzip":
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nop, err
+		}
+		return zr, func() { zr.Close() }, nil
+	case "zstd":
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, nop, err
+		}
+		return zr, zr.Close, nil
+	case "bzip2":
+		return bzip2.NewReader(br), nop, nil
+	case "zlib":
+		zr, err := zlib.NewReader(br)
+		if err != nil {
+			return nil, nop, err
+		}
+		return zr, func() { zr.Close() }, nil
+	}
+	return br, nop, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// --style components beyond numbers and changes, which just turn on -n and
+// --diff
+var (
+	styleGrid     bool
+	styleHeader   bool
+	styleFileSize bool
+	styleRule     bool
+)
+
+func parseStyle(s string) {
+	for _, c := range strings.Split(s, ",") {
+		switch strings.TrimSpace(c) {
+		case "numbers":
+			flagNumber = true
+		case "changes":
+			flagDiff = true
+		case "grid":
+			styleGrid = true
+		case "header":
+			styleHeader = true
+		case "header-filesize":
+			styleHeader = true
+			styleFileSize = true
+		case "rule":
+			styleRule = true
+		case "full":
+			flagNumber = true
+			flagDiff = true
+			styleGrid = true
+			styleHeader = true
+			styleFileSize = true
+		case "plain":
+			flagNumber = false
+			flagDiff = false
+			styleGrid = false
+			styleHeader = false
+			styleFileSize = false
+			styleRule = false
+		case "":
+		default:
+			fmt.Fprintf(os.Stderr, "invalid --style component %q: want numbers, changes, grid, header, header-filesize, rule, full or plain\n", c)
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gcat/highlight"
+)
+
+// one [glob] section of an .editorconfig, with the properties cat cares about
+type editorSection struct {
+	re         *regexp.Regexp
+	tabWidth   string
+	indentSize string
+}
+
+type editorConfig struct {
+	root     bool
+	sections []editorSection
+}
+
+var (
+	editorConfigsMu sync.Mutex
+	editorConfigs   = map[string]*editorConfig{} // by directory, nil when there's none
+)
+
+// the tab width .editorconfig files give fpath: tab_width, or indent_size
+// when that's a number and tab_width isnt set. files are read from the
+// directory of fpath up to one with root = true, closer ones winning like
+// later sections in the same file do. 0 if nothing says
+func editorTabWidth(fpath string) int {
+	abs, err := filepath.Abs(fpath)
+	if err != nil {
+		return 0
+	}
+	path := filepath.ToSlash(abs)
+
+	var tabWidth, indentSize string
+	for dir := filepath.Dir(abs); ; {
+		if ec := readEditorConfig(dir); ec != nil {
+			prefix := filepath.ToSlash(dir)
+			if !strings.HasSuffix(prefix, "/") {
+				prefix += "/"
+			}
+			rel := strings.TrimPrefix(path, prefix)
+			// later sections win, and nearer files beat ones further up
+			for i := len(ec.sections) - 1; i >= 0; i-- {
+				s := ec.sections[i]
+				if !s.re.MatchString(rel) {
+					continue
+				}
+				if tabWidth == "" {
+					tabWidth = s.tabWidth
+				}
+				if indentSize == "" {
+					indentSize = s.indentSize
+				}
+			}
+			if ec.root {
+				break
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+
+	for _, v := range []string{tabWidth, indentSize} {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 0
+}
+
+func readEditorConfig(dir string) *editorConfig {
+	editorConfigsMu.Lock()
+	defer editorConfigsMu.Unlock()
+	if ec, ok := editorConfigs[dir]; ok {
+		return ec
+	}
+
+	var ec *editorConfig
+	if data, err := os.ReadFile(filepath.Join(dir, ".editorconfig")); err == nil {
+		ec = parseEditorConfig(string(data))
+	}
+	editorConfigs[dir] = ec
+	return ec
+}
+
+func parseEditorConfig(data string) *editorConfig {
+	ec := &editorConfig{}
+	var cur *editorSection
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			cur = nil
+			re, err := editorGlob(line[1 : len(line)-1])
+			if err != nil {
+				continue
+			}
+			ec.sections = append(ec.sections, editorSection{re: re})
+			cur = &ec.sections[len(ec.sections)-1]
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.ToLower(strings.TrimSpace(value))
+		switch {
+		case cur == nil && key == "root":
+			ec.root = value == "true"
+		case cur != nil && key == "tab_width":
+			cur.tabWidth = value
+		case cur != nil && key == "indent_size":
+			cur.indentSize = value
+		}
+	}
+	return ec
+}
+
+// editorconfig globs: a glob without a slash matches the name in any
+// directory, one with a slash is from the .editorconfig's directory
+func editorGlob(glob string) (*regexp.Regexp, error) {
+	switch {
+	case strings.HasPrefix(glob, "/"):
+		glob = glob[1:]
+	case !strings.Contains(glob, "/"):
+		glob = "**/" + glob
+	}
+	return highlight.GlobRegexp(glob)
+}
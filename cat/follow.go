@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"gcat/highlight"
+)
+
+// how often a followed file is checked for more once it's been read to the end
+const followPoll = 250 * time.Millisecond
+
+// reads a file like tail -F does: at the end it waits for more instead of
+// giving io.EOF, starts over from the top when the file is truncated, and
+// when the name points at a new file (log rotation) it finishes the old one
+// and opens the new one. highlight.Render keeps lexing and counting lines
+// across all of it as if it were one long input, peeking and counting
+// through the Live methods
+type follower struct {
+	name   string
+	f      *os.File
+	offset int64
+}
+
+func newFollower(name string, f *os.File) *follower {
+	offset, _ := f.Seek(0, io.SeekCurrent)
+	return &follower{name: name, f: f, offset: offset}
+}
+
+func (fl *follower) Read(b []byte) (int, error) {
+	for {
+		n, err := fl.f.Read(b)
+		fl.offset += int64(n)
+		if n > 0 {
+			return n, nil
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		// at the end: see if it got replaced or cut short before waiting
+		if fl.reopen() {
+			continue
+		}
+		if st, err := fl.f.Stat(); err == nil && st.Size() < fl.offset {
+			fmt.Fprintf(os.Stderr, "%s was truncated, printing it from the start\n", fl.name)
+			if _, err := fl.f.Seek(0, io.SeekStart); err != nil {
+				return 0, err
+			}
+			fl.offset = 0
+			continue
+		}
+		time.Sleep(followPoll)
+	}
+}
+
+// switches to whatever is at the name now if it isnt the file being read.
+// a missing name is left for later, it's usually mid-rotation
+func (fl *follower) reopen() bool {
+	st, err := os.Stat(fl.name)
+	if err != nil {
+		return false
+	}
+	cur, err := fl.f.Stat()
+	if err != nil || os.SameFile(st, cur) {
+		return false
+	}
+	f, err := os.Open(fl.name)
+	if err != nil {
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "%s was replaced, following the new file\n", fl.name)
+	fl.f.Close()
+	fl.f = f
+	fl.offset = 0
+	return true
+}
+
+func (fl *follower) Close() error {
+	return fl.f.Close()
+}
+
+// what's next in the file, for the encoding to be worked out from. the
+// follower is left where it was
+func (fl *follower) Peek(b []byte) (int, error) {
+	return fl.f.ReadAt(b, fl.offset)
+}
+
+// the lines in the file as it is now, for line ranges counted from the end.
+// the follower is left where it was
+func (fl *follower) CountLines() (int, error) {
+	st, err := fl.f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return highlight.CountLines(io.NewSectionReader(fl.f, fl.offset, st.Size()-fl.offset))
+}
@@ -0,0 +1,82 @@
+package highlight
+
+import "strings"
+
+// StripANSI drops the CSI escapes (colours and the like) from s
+func StripANSI(s string) string {
+	var b strings.Builder
+	i := 0
+	for i < len(s) {
+		if s[i] == '\x1b' && i+1 < len(s) && s[i+1] == '[' {
+			j := i + 2
+			for j < len(s) && (s[j] < 0x40 || s[j] > 0x7e) {
+				j++
+			}
+			if j < len(s) {
+				j++
+			}
+			i = j
+			continue
+		}
+		b.WriteByte(s[i])
+		i++
+	}
+	return b.String()
+}
+
+// GNU cat -v: control characters as ^X, DEL as ^?, and bytes with the high
+// bit set as M- followed by the same for the low 7 bits. tabs and the
+// newline ending the line are left alone
+func showNonprinting(line string) string {
+	body, nl := strings.CutSuffix(line, "\n")
+
+	i := 0
+	for i < len(body) && (body[i] >= 0x20 && body[i] < 0x7f || body[i] == '\t') {
+		i++
+	}
+	if i == len(body) {
+		return line
+	}
+
+	var b strings.Builder
+	b.WriteString(body[:i])
+	for ; i < len(body); i++ {
+		c := body[i]
+		high := c >= 0x80
+		if high {
+			b.WriteString("M-")
+			c -= 0x80
+		}
+		switch {
+		case c == '\t' && !high:
+			b.WriteByte(c)
+		case c < 0x20:
+			b.WriteByte('^')
+			b.WriteByte(c + 0x40)
+		case c == 0x7f:
+			b.WriteString("^?")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	if nl {
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func appendBeforeTrailingReset(line, insert string) string {
+	if len(line) == 0 {
+		return insert
+	}
+	if line[len(line)-1] == 'm' {
+		j := len(line) - 2
+		for j >= 0 && line[j] != '\x1b' {
+			j--
+		}
+		if j >= 0 && j+1 < len(line) && line[j+1] == '[' {
+			return line[:j] + insert + line[j:]
+		}
+	}
+	return line + insert
+}
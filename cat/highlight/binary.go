@@ -0,0 +1,140 @@
+package highlight
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// how much of the input is looked at to decide if it's binary
+const sniffSize = 8192
+
+// NUL bytes or more than a tenth of the sample not being UTF-8
+func isBinary(sample []byte) bool {
+	if bytes.IndexByte(sample, 0) >= 0 {
+		return true
+	}
+
+	invalid := 0
+	for i := 0; i < len(sample); {
+		r, size := utf8.DecodeRune(sample[i:])
+		if r == utf8.RuneError && size == 1 {
+			// a rune cut off by the end of the sample is fine
+			if len(sample)-i < utf8.UTFMax && !utf8.FullRune(sample[i:]) {
+				break
+			}
+			invalid++
+		}
+		i += size
+	}
+	return invalid*10 > len(sample)
+}
+
+// prints a binary input the way Options.Binary says
+func catBinary(r io.Reader, out io.Writer, o *Options) error {
+	switch o.Binary {
+	case BinaryHex:
+		return hexdump(out, r, o.Color)
+	case BinarySkip:
+		size, err := io.Copy(io.Discard, r)
+		if err != nil {
+			return err
+		}
+		if o.Color {
+			fmt.Fprintf(out, "\x1b[2;37m<binary file, %d bytes>\x1b[0m\n", size)
+		} else {
+			fmt.Fprintf(out, "<binary file, %d bytes>\n", size)
+		}
+		return nil
+	default:
+		_, err := io.Copy(out, r)
+		return err
+	}
+}
+
+// hexdump -C layout: offset, two groups of 8 bytes, then the printable ones.
+// colours go by kind of byte like hexyl does
+func hexdump(w io.Writer, r io.Reader, useColor bool) error {
+	br := bufio.NewReaderSize(r, 64*1024)
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	row := make([]byte, 16)
+	var line strings.Builder
+	offset := 0
+	for {
+		n, err := io.ReadFull(br, row)
+		if n == 0 {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return err
+		}
+
+		line.Reset()
+		if useColor {
+			fmt.Fprintf(&line, "\x1b[2;37m%08x\x1b[0m  ", offset)
+		} else {
+			fmt.Fprintf(&line, "%08x  ", offset)
+		}
+		for i := range 16 {
+			if i == 8 {
+				line.WriteByte(' ')
+			}
+			if i >= n {
+				line.WriteString("   ")
+				continue
+			}
+			writeByteColored(&line, fmt.Sprintf("%02x", row[i]), row[i], useColor)
+			line.WriteByte(' ')
+		}
+		line.WriteString(" |")
+		for _, c := range row[:n] {
+			ch := "."
+			if c >= 0x20 && c < 0x7f {
+				ch = string(rune(c))
+			}
+			writeByteColored(&line, ch, c, useColor)
+		}
+		line.WriteString("|\n")
+		bw.WriteString(line.String())
+
+		offset += n
+		if err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if useColor {
+		fmt.Fprintf(bw, "\x1b[2;37m%08x\x1b[0m\n", offset)
+	} else {
+		fmt.Fprintf(bw, "%08x\n", offset)
+	}
+	return nil
+}
+
+func writeByteColored(b *strings.Builder, s string, c byte, useColor bool) {
+	if !useColor {
+		b.WriteString(s)
+		return
+	}
+	var clr string
+	switch {
+	case c == 0:
+		clr = "90" // grey
+	case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+		clr = "32" // green
+	case c > 0x20 && c < 0x7f:
+		clr = "36" // cyan
+	case c < 0x20 || c == 0x7f:
+		clr = "35" // magenta
+	default:
+		clr = "33" // yellow
+	}
+	fmt.Fprintf(b, "\x1b[%sm%s\x1b[0m", clr, s)
+}
@@ -0,0 +1,242 @@
+package highlight
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/formatters"
+)
+
+// TrueColor is the Depth of a terminal that takes 24-bit colours
+const TrueColor = 1 << 24
+
+// DetectColorDepth works out how many colours the terminal can show, from
+// COLORTERM, then terminfo's max_colors for $TERM, then guessing from the
+// name
+func DetectColorDepth() int {
+	switch strings.ToLower(os.Getenv("COLORTERM")) {
+	case "truecolor", "24bit":
+		return TrueColor
+	}
+
+	name := os.Getenv("TERM")
+	if name == "" {
+		// no TERM is mostly windows, where terminals do true colour
+		return TrueColor
+	}
+	if n := terminfoColors(name); n > 0 {
+		return normalizeDepth(n)
+	}
+	switch {
+	case strings.HasSuffix(name, "-direct"):
+		return TrueColor
+	case strings.Contains(name, "256color"):
+		return 256
+	case name == "linux" || strings.HasPrefix(name, "vt") || name == "ansi":
+		return 8
+	}
+	return 256
+}
+
+func normalizeDepth(n int) int {
+	switch {
+	case n >= TrueColor:
+		return TrueColor
+	case n >= 256:
+		return 256
+	case n >= 16:
+		return 16
+	}
+	return 8
+}
+
+// ParseColorDepth reads a Depth as --colors takes it: 8, 16, 256 or
+// 16m/24bit/truecolor
+func ParseColorDepth(s string) (int, error) {
+	switch strings.ToLower(s) {
+	case "16m", "24bit", "truecolor":
+		return TrueColor, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 8 {
+		return 0, fmt.Errorf("want 8, 16, 256 or 16m")
+	}
+	return normalizeDepth(n), nil
+}
+
+func depthFormatter(depth int) chroma.Formatter {
+	switch depth {
+	case TrueColor:
+		return formatters.TTY16m
+	case 256:
+		return formatters.TTY256
+	case 16:
+		return formatters.TTY16
+	}
+	return formatters.TTY8
+}
+
+// reads max_colors out of the compiled terminfo entry for name, 0 if there
+// isnt one to be found
+func terminfoColors(name string) int {
+	var dirs []string
+	if d := os.Getenv("TERMINFO"); d != "" {
+		dirs = append(dirs, d)
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, filepath.Join(home, ".terminfo"))
+	}
+	for _, d := range strings.Split(os.Getenv("TERMINFO_DIRS"), ":") {
+		if d == "" {
+			d = "/usr/share/terminfo"
+		}
+		dirs = append(dirs, d)
+	}
+	dirs = append(dirs, "/etc/terminfo", "/lib/terminfo", "/usr/share/terminfo")
+
+	for _, d := range dirs {
+		// entries live under their first letter, or its hex code on macos
+		for _, sub := range []string{name[:1], fmt.Sprintf("%x", name[0])} {
+			data, err := os.ReadFile(filepath.Join(d, sub, name))
+			if err == nil {
+				return parseTerminfoColors(data)
+			}
+		}
+	}
+	return 0
+}
+
+// the compiled format is a header of six shorts (magic, sizes of the names,
+// booleans, numbers, strings and string table), then the names, the
+// booleans padded to an even offset, then the numbers. max_colors is number
+// 13. the 01036 magic has 32 bit numbers instead of 16
+func parseTerminfoColors(data []byte) int {
+	const maxColors = 13
+	if len(data) < 12 {
+		return 0
+	}
+	short := func(i int) int { return int(int16(binary.LittleEndian.Uint16(data[i:]))) }
+
+	numSize := 2
+	switch short(0) {
+	case 0o432:
+	case 0o1036:
+		numSize = 4
+	default:
+		return 0
+	}
+	namesSize, boolCount, numCount := short(2), short(4), short(6)
+	if maxColors >= numCount {
+		return 0
+	}
+
+	off := 12 + namesSize + boolCount
+	if off%2 == 1 {
+		off++
+	}
+	off += maxColors * numSize
+	if off+numSize > len(data) {
+		return 0
+	}
+	if numSize == 4 {
+		return int(int32(binary.LittleEndian.Uint32(data[off:])))
+	}
+	return short(off)
+}
+
+// foreground escape for c at the given depth
+func fgEscape(c chroma.Colour, depth int) string {
+	return colorEscape(c, depth, false)
+}
+
+func bgEscape(c chroma.Colour, depth int) string {
+	return colorEscape(c, depth, true)
+}
+
+func colorEscape(c chroma.Colour, depth int, bg bool) string {
+	switch depth {
+	case TrueColor:
+		if bg {
+			return fmt.Sprintf("\x1b[48;2;%d;%d;%dm", c.Red(), c.Green(), c.Blue())
+		}
+		return fmt.Sprintf("\x1b[38;2;%d;%d;%dm", c.Red(), c.Green(), c.Blue())
+	case 256:
+		if bg {
+			return fmt.Sprintf("\x1b[48;5;%dm", nearest256(c))
+		}
+		return fmt.Sprintf("\x1b[38;5;%dm", nearest256(c))
+	}
+
+	i := nearestBasic(c, depth)
+	code := 30 + i
+	if i >= 8 {
+		code = 90 + i - 8
+	}
+	if bg {
+		code += 10
+	}
+	return fmt.Sprintf("\x1b[%dm", code)
+}
+
+// the xterm 6x6x6 cube or the grey ramp, whichever is closer
+func nearest256(c chroma.Colour) int {
+	level := func(v uint8) int {
+		switch {
+		case v < 48:
+			return 0
+		case v < 115:
+			return 1
+		}
+		return (int(v) - 35) / 40
+	}
+	steps := []int{0, 95, 135, 175, 215, 255}
+	r, g, b := level(c.Red()), level(c.Green()), level(c.Blue())
+	cube := 16 + 36*r + 6*g + b
+	cubeDist := dist(c, steps[r], steps[g], steps[b])
+
+	avg := (int(c.Red()) + int(c.Green()) + int(c.Blue())) / 3
+	grey := min(max((avg-3)/10, 0), 23)
+	v := 8 + grey*10
+	if dist(c, v, v, v) < cubeDist {
+		return 232 + grey
+	}
+	return cube
+}
+
+// xterms default 16 colours
+var basicColors = [16][3]int{
+	{0, 0, 0}, {205, 0, 0}, {0, 205, 0}, {205, 205, 0},
+	{0, 0, 238}, {205, 0, 205}, {0, 205, 205}, {229, 229, 229},
+	{127, 127, 127}, {255, 0, 0}, {0, 255, 0}, {255, 255, 0},
+	{92, 92, 255}, {255, 0, 255}, {0, 255, 255}, {255, 255, 255},
+}
+
+func nearestBasic(c chroma.Colour, depth int) int {
+	n := min(depth, 16)
+	best, bestDist := 0, -1
+	for i, p := range basicColors[:n] {
+		if d := dist(c, p[0], p[1], p[2]); bestDist < 0 || d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best
+}
+
+func dist(c chroma.Colour, r, g, b int) int {
+	dr, dg, db := int(c.Red())-r, int(c.Green())-g, int(c.Blue())-b
+	return dr*dr + dg*dg + db*db
+}
+
+// the colour for line numbers and other gutter bits: the themes line number
+// colour, or dim where eight colours cant get near the themes grey
+func gutterEscape(style *chroma.Style, depth int) string {
+	if c := style.Get(chroma.LineNumbers).Colour; c.IsSet() && depth > 8 {
+		return fgEscape(c, depth)
+	}
+	return "\x1b[2;37m"
+}
@@ -0,0 +1,122 @@
+package highlight
+
+import (
+	"fmt"
+	"strings"
+)
+
+// the continuation marker needs wrapping to mark anything
+func (p *linePrinter) wrapMarker() bool {
+	return p.o.WrapMarker && p.o.Wrap != WrapNever
+}
+
+// columns before the content, not counting the grid bar
+func (p *linePrinter) gutterWidth() int {
+	w := p.numWidth
+	if p.wrapMarker() && w == 0 {
+		w = 2 // room for the marker
+	}
+	if p.o.Diff {
+		w += 2
+	}
+	return w
+}
+
+// the grid bar only goes between a gutter and the content
+func (p *linePrinter) barWidth() int {
+	if p.o.Grid && p.gutterWidth() > 0 {
+		return 2
+	}
+	return 0
+}
+
+func (p *linePrinter) writeBar(b *strings.Builder) {
+	if p.barWidth() == 0 {
+		return
+	}
+	if p.o.Color {
+		b.WriteString(p.gutter + "│\x1b[0m ")
+	} else {
+		b.WriteString("│ ")
+	}
+}
+
+// a horizontal grid line, with joint where it crosses the bar
+func (p *linePrinter) rule(joint string) {
+	var line string
+	if g := p.gutterWidth(); p.barWidth() > 0 {
+		line = strings.Repeat("─", g) + joint + strings.Repeat("─", max(0, p.o.Width-g-1))
+	} else {
+		line = strings.Repeat("─", p.o.Width)
+	}
+	if p.o.Color {
+		fmt.Fprintf(p.out, "%s%s\x1b[0m\n", p.gutter, line)
+	} else {
+		fmt.Fprintln(p.out, line)
+	}
+}
+
+// prints the header and top border. waits for the first line so the
+// gutter width is known by then
+func (p *linePrinter) begin() {
+	if p.started {
+		return
+	}
+	p.started = true
+
+	if p.o.Grid {
+		p.rule("┬")
+	}
+	var b strings.Builder
+	for _, h := range p.header {
+		b.Reset()
+		if p.barWidth() > 0 {
+			b.WriteString(strings.Repeat(" ", p.gutterWidth()))
+			p.writeBar(&b)
+		}
+		b.WriteString(h)
+		fmt.Fprintln(p.out, b.String())
+	}
+	if p.o.Grid && len(p.header) > 0 {
+		p.rule("┼")
+	}
+}
+
+func (p *linePrinter) end() {
+	p.begin()
+	if p.o.Grid {
+		p.rule("┴")
+	}
+}
+
+// encoding is left out when it's "", plain UTF-8
+func fileHeader(name string, size int64, encoding string, o *Options) []string {
+	var header []string
+	if o.Color {
+		header = append(header, fmt.Sprintf("File: \x1b[1m%s\x1b[0m", name))
+	} else {
+		header = append(header, "File: "+name)
+	}
+	if o.FileSize && size >= 0 {
+		header = append(header, "Size: "+HumanSize(size))
+	}
+	if encoding != "" {
+		header = append(header, "Encoding: "+encoding)
+	}
+	return header
+}
+
+// HumanSize gives n bytes in B, KiB, MiB and so on
+func HumanSize(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	size := float64(n) / 1024
+	for _, unit := range []string{"KiB", "MiB", "GiB"} {
+		if size < 1024 {
+			return fmt.Sprintf("%.1f %s", size, unit)
+		}
+		size /= 1024
+	}
+	return fmt.Sprintf("%.1f TiB", size)
+}
@@ -0,0 +1,161 @@
+package highlight
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+const (
+	changeAdded    = '+'
+	changeModified = '~'
+	changeRemoved  = '-' // lines were removed right before (or, at the end, after) this one
+)
+
+// Changes has one entry per line of the working copy: '+' for an added
+// line, '~' for a modified one, '-' where lines were removed, 0 where it
+// matches HEAD
+type Changes []byte
+
+// GitChanges compares the file at fpath with its version in HEAD. files
+// outside a work tree or not committed yet have no changes to show and give
+// nil, as does a file git has but cant give back
+func GitChanges(fpath string) (Changes, error) {
+	abs, err := filepath.Abs(fpath)
+	if err != nil {
+		return nil, nil
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		abs = resolved
+	}
+
+	repo, err := git.PlainOpenWithOptions(filepath.Dir(abs), &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return nil, nil
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return nil, nil
+	}
+	root := wt.Filesystem.Root()
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return nil, nil
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return nil, nil
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, nil
+	}
+	file, err := commit.File(filepath.ToSlash(rel))
+	if err != nil {
+		return nil, nil
+	}
+	old, err := file.Contents()
+	if err != nil {
+		return nil, fmt.Errorf("cant read %s from HEAD: %w", rel, err)
+	}
+	cur, err := os.ReadFile(fpath)
+	if err != nil {
+		return nil, nil
+	}
+
+	return diffLines(old, string(cur)), nil
+}
+
+func diffLines(old, cur string) Changes {
+	changes := make(Changes, countLines(cur))
+	diffs := diff.Do(old, cur)
+
+	line := 0
+	for i := 0; i < len(diffs); i++ {
+		d := diffs[i]
+		n := countLines(d.Text)
+		switch d.Type {
+		case diffmatchpatch.DiffEqual:
+			line += n
+		case diffmatchpatch.DiffInsert:
+			changes.mark(line, n, changeAdded)
+			line += n
+		case diffmatchpatch.DiffDelete:
+			// a removal followed by an insertion is a change to those lines
+			if i+1 < len(diffs) && diffs[i+1].Type == diffmatchpatch.DiffInsert {
+				m := countLines(diffs[i+1].Text)
+				changes.mark(line, m, changeModified)
+				line += m
+				i++
+				continue
+			}
+			at := min(line, len(changes)-1)
+			if at >= 0 && changes[at] == 0 {
+				changes[at] = changeRemoved
+			}
+		}
+	}
+	return changes
+}
+
+func (c Changes) mark(from, n int, kind byte) {
+	for i := from; i < from+n && i < len(c); i++ {
+		c[i] = kind
+	}
+}
+
+// at is the 0-based line in the working copy
+func (c Changes) at(i int) byte {
+	if i < 0 || i >= len(c) {
+		return 0
+	}
+	return c[i]
+}
+
+// whether line i is within context lines of a change
+func (c Changes) near(i, context int) bool {
+	for j := i - context; j <= i+context; j++ {
+		if c.at(j) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func countLines(s string) int {
+	n := strings.Count(s, "\n")
+	if s != "" && !strings.HasSuffix(s, "\n") {
+		n++
+	}
+	return n
+}
+
+// the gutter marker for a line, coloured like git diff
+func writeChangeMarker(w io.Writer, kind byte, useColor bool) {
+	if kind == 0 {
+		io.WriteString(w, "  ")
+		return
+	}
+	if !useColor {
+		fmt.Fprintf(w, "%c ", kind)
+		return
+	}
+	clr := "32"
+	switch kind {
+	case changeModified:
+		clr = "33"
+	case changeRemoved:
+		clr = "31"
+	}
+	fmt.Fprintf(w, "\x1b[%sm%c\x1b[0m ", clr, kind)
+}
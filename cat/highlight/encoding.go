@@ -0,0 +1,152 @@
+package highlight
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/encoding/unicode/utf32"
+	"golang.org/x/text/transform"
+)
+
+// byte order marks, longest first so UTF-32LE isnt taken for UTF-16LE
+var boms = []struct {
+	mark []byte
+	name string
+	enc  encoding.Encoding
+}{
+	{[]byte{0x00, 0x00, 0xfe, 0xff}, "UTF-32BE", utf32.UTF32(utf32.BigEndian, utf32.ExpectBOM)},
+	{[]byte{0xff, 0xfe, 0x00, 0x00}, "UTF-32LE", utf32.UTF32(utf32.LittleEndian, utf32.ExpectBOM)},
+	{[]byte{0xef, 0xbb, 0xbf}, "UTF-8 with BOM", unicode.UTF8BOM},
+	{[]byte{0xfe, 0xff}, "UTF-16BE", unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM)},
+	{[]byte{0xff, 0xfe}, "UTF-16LE", unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM)},
+}
+
+// LookupEncoding finds an encoding by its IANA name or alias (latin1,
+// utf-16le, shift_jis) or the name browsers know it by (cp1252, ucs-2), and
+// gives a name for it people know: the MIME one (ISO-8859-1, not
+// ISO_8859-1:1987), the browsers one, or the IANA one when it has neither
+func LookupEncoding(name string) (encoding.Encoding, string, error) {
+	enc, err := ianaindex.IANA.Encoding(name)
+	if err != nil || enc == nil {
+		if enc, err = htmlindex.Get(name); err != nil {
+			return nil, "", fmt.Errorf("unknown encoding")
+		}
+	}
+	if n, err := ianaindex.MIME.Name(enc); err == nil && n != "" {
+		return enc, n, nil
+	}
+	if n, err := htmlindex.Name(enc); err == nil {
+		return enc, n, nil
+	}
+	n, _ := ianaindex.IANA.Name(enc)
+	return enc, n, nil
+}
+
+// works out what r is encoded in and gives back a reader of it as UTF-8,
+// plus the encodings name for the header. plain UTF-8 is passed through
+// untouched and named "". without an Encoding it goes by the BOM, and input
+// that isnt UTF-8 but reads like text is taken to be windows-1252, which is
+// latin1 with the printable characters where latin1 has controls. binary
+// says the raw bytes dont look like text at all, r is then left as it is.
+// -v shows the bytes themselves, so it turns off the guessing past the BOM
+func decodeInput(r io.Reader, o *Options) (_ io.Reader, encName string, binary bool, _ error) {
+	sample, r, err := peekSample(r)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	if o.Encoding != nil {
+		if o.Encoding == unicode.UTF8 {
+			return r, "", false, nil
+		}
+		// a BOM still wins, and is dropped either way
+		dec := unicode.BOMOverride(o.Encoding.NewDecoder())
+		return transform.NewReader(r, dec), o.EncodingName, false, nil
+	}
+
+	for _, b := range boms {
+		if bytes.HasPrefix(sample, b.mark) {
+			return transform.NewReader(r, b.enc.NewDecoder()), b.name, false, nil
+		}
+	}
+	if o.ShowNonprinting {
+		return r, "", false, nil
+	}
+	if legacyText(sample) {
+		return transform.NewReader(r, charmap.Windows1252.NewDecoder()), "windows-1252", false, nil
+	}
+	return r, "", isBinary(sample), nil
+}
+
+// the first read of r, without taking it out of r. regular files are read
+// with ReadAt and Live ones peeked at, so they stay what they were
+func peekSample(r io.Reader) ([]byte, io.Reader, error) {
+	buf := make([]byte, sniffSize)
+	if l, ok := r.(Live); ok {
+		n, err := l.Peek(buf)
+		if err != nil && err != io.EOF {
+			return nil, nil, err
+		}
+		return buf[:n], l, nil
+	}
+	if f, ok := r.(*os.File); ok {
+		if st, err := f.Stat(); err == nil && st.Mode().IsRegular() {
+			if pos, err := f.Seek(0, io.SeekCurrent); err == nil {
+				n, err := f.ReadAt(buf, pos)
+				if err != nil && err != io.EOF {
+					return nil, nil, err
+				}
+				return buf[:n], f, nil
+			}
+		}
+	}
+	n, err := r.Read(buf)
+	if err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+	buf = buf[:n]
+	return buf, io.MultiReader(bytes.NewReader(buf), r), nil
+}
+
+// not UTF-8, but no NULs and hardly any control characters, so most likely
+// an 8 bit encoding rather than a binary. the bytes windows-1252 leaves
+// undefined count as controls: no text in it or in latin1, greek or the
+// other ISO-8859 sets has them, while a binary has them like any other
+func legacyText(sample []byte) bool {
+	if bytes.IndexByte(sample, 0) >= 0 {
+		return false
+	}
+	valid := utf8.Valid(sample)
+	if !valid {
+		// a rune cut off by the end of the sample doesnt count
+		for i := 1; i < utf8.UTFMax && i <= len(sample); i++ {
+			if utf8.Valid(sample[:len(sample)-i]) && !utf8.FullRune(sample[len(sample)-i:]) {
+				valid = true
+				break
+			}
+		}
+	}
+	if valid {
+		return false
+	}
+
+	controls := 0
+	for _, c := range sample {
+		switch {
+		case c < 0x20 && !strings.ContainsRune("\t\n\v\f\r\x1b", rune(c)):
+			controls++
+		case c == 0x81 || c == 0x8d || c == 0x8f || c == 0x90 || c == 0x9d:
+			controls++
+		}
+	}
+	return controls*100 < len(sample)
+}
@@ -0,0 +1,71 @@
+package highlight
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestLegacyText(t *testing.T) {
+	noise := make([]byte, 4000)
+	rnd := rand.New(rand.NewSource(1))
+	for i := range noise {
+		noise[i] = byte(0x80 + rnd.Intn(0x80))
+	}
+	tests := []struct {
+		name   string
+		sample string
+		legacy bool
+	}{
+		{"short french", "n\xe9 \xe0 \xe9t\xe9\n", true},
+		{"latin1 word", "caf\xe9\n", true},
+		{"greek", "\xc3\xe5\xe9\xdc \xf3\xef\xf5 \xca\xfc\xf3\xec\xe5\n", true},
+		{"utf-8", "né\n", false},
+		{"ascii", "plain\n", false},
+		{"nul", "caf\xe9\x00\n", false},
+		{"controls", "\xe9\x01\x02\x03\x04", false},
+		{"high bytes all over", string(noise), false},
+	}
+	for _, tt := range tests {
+		if got := legacyText([]byte(tt.sample)); got != tt.legacy {
+			t.Errorf("%s: legacyText(%q) = %v, want %v", tt.name, tt.sample, got, tt.legacy)
+		}
+	}
+}
+
+func TestRenderLegacy(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"short french", "n\xe9 \xe0 \xe9t\xe9\n", "né à été\n"},
+		{"greek", "\xc3\xe5\xe9\xdc \xf3\xef\xf5\n", "ÃåéÜ óïõ\n"},
+	}
+	for _, tt := range tests {
+		got, _ := render(t, tt.in, Options{Language: "plaintext", Binary: BinaryHex})
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLookupEncoding(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"latin1", "ISO-8859-1"},
+		{"cp1252", "windows-1252"},
+		{"shift_jis", "Shift_JIS"},
+		{"utf-16le", "UTF-16LE"},
+		{"iso-8859-7", "ISO-8859-7"},
+	}
+	for _, tt := range tests {
+		_, got, err := LookupEncoding(tt.name)
+		if err != nil || got != tt.want {
+			t.Errorf("LookupEncoding(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+	if _, _, err := LookupEncoding("klingon"); err == nil {
+		t.Errorf("LookupEncoding(%q) didnt fail", "klingon")
+	}
+}
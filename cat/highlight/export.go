@@ -0,0 +1,235 @@
+package highlight
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/formatters/svg"
+)
+
+// html, svg and rtf are written by the formatter in one go, with line
+// numbers and highlighted lines put in as part of the document instead of
+// by the line printer. the input is read whole for that. like Render it
+// gives back the number the last numbered line got
+func exportDocument(name string, r io.Reader, out io.Writer, o *Options) (int, error) {
+	lineNum := o.FirstNumber
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return lineNum, err
+	}
+	text := string(raw)
+
+	lexer := pickLexer(name, text, true, o)
+	iterator, err := lexer.Tokenise(nil, text)
+	if err != nil {
+		return lineNum, fmt.Errorf("highlight error: %w", err)
+	}
+	lines := chroma.SplitTokensIntoLines(iterator.Tokens())
+
+	shown := resolveRanges(o.LineRanges, len(lines))
+	highlighted := resolveRanges(o.HighlightRanges, len(lines))
+	numWidth := len(strconv.Itoa(lineNum + len(lines)))
+
+	var tokens []chroma.Token
+	var marked []int // 1-based lines of the document to highlight
+	kept := 0
+	for i, l := range lines {
+		// numbers go by the input, hidden lines count too
+		blank := blankTokens(l)
+		if o.Number || (o.NumberNonblank && !blank) {
+			lineNum++
+		}
+		if shown != nil && !inSpans(shown, i+1) {
+			continue
+		}
+		kept++
+		if inSpans(highlighted, i+1) {
+			marked = append(marked, kept)
+		}
+		switch {
+		case o.NumberNonblank && blank:
+			tokens = append(tokens, chroma.Token{
+				Type:  chroma.LineNumbers,
+				Value: strings.Repeat(" ", numWidth+1),
+			})
+		case o.numbered():
+			tokens = append(tokens, chroma.Token{
+				Type:  chroma.LineNumbers,
+				Value: fmt.Sprintf("%*d ", numWidth, lineNum),
+			})
+		}
+		tokens = append(tokens, l...)
+	}
+
+	style := o.Style
+	switch o.Document {
+	case DocumentHTML:
+		var ranges [][2]int
+		for _, m := range marked {
+			ranges = append(ranges, [2]int{m, m})
+		}
+		f := html.New(html.Standalone(true), html.WithClasses(false), html.TabWidth(o.TabWidth), html.HighlightLines(ranges))
+		err = f.Format(out, style, chroma.Literator(tokens...))
+	case DocumentSVG:
+		err = formatSVG(out, style, tokens, marked)
+	case DocumentRTF:
+		err = formatRTF(out, style, tokens, marked)
+	}
+	if err != nil {
+		return lineNum, fmt.Errorf("format error: %w", err)
+	}
+	return lineNum, nil
+}
+
+// a line of nothing but whitespace, which -b leaves unnumbered like the
+// line printer does
+func blankTokens(line []chroma.Token) bool {
+	for _, t := range line {
+		if strings.TrimSpace(t.Value) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// the svg formatter has no notion of highlighted lines, so a rect per line
+// goes in before the text, where it lays out lines 1.2em apart
+func formatSVG(w io.Writer, style *chroma.Style, tokens []chroma.Token, marked []int) error {
+	var buf bytes.Buffer
+	if err := svg.New().Format(&buf, style, chroma.Literator(tokens...)); err != nil {
+		return err
+	}
+	doc := buf.String()
+
+	if len(marked) > 0 {
+		bg := highlightBackground(style)
+		var rects strings.Builder
+		for _, m := range marked {
+			fmt.Fprintf(&rects, "<rect x=\"0\" y=\"%fem\" width=\"100%%\" height=\"1.2em\" fill=\"%s\"/>\n",
+				1.2*float64(m-1)+0.25, bg.String())
+		}
+		// right after the <g> holding the text
+		if i := strings.Index(doc, "<g "); i >= 0 {
+			if j := strings.Index(doc[i:], ">\n"); j >= 0 {
+				at := i + j + 2
+				doc = doc[:at] + rects.String() + doc[at:]
+			}
+		}
+	}
+	_, err := io.WriteString(w, doc)
+	return err
+}
+
+func highlightBackground(style *chroma.Style) chroma.Colour {
+	bg := style.Get(chroma.LineHighlight).Background
+	if !bg.IsSet() {
+		bg = chroma.NewColour(0x3e, 0x3d, 0x32)
+	}
+	return bg
+}
+
+// a minimal rtf writer: one monospace font, a colour table built from the
+// style, bold/italic/underline, and \highlight for the marked lines
+func formatRTF(w io.Writer, style *chroma.Style, tokens []chroma.Token, marked []int) error {
+	colours := []chroma.Colour{}
+	index := map[chroma.Colour]int{}
+	colourIndex := func(c chroma.Colour) int {
+		if i, ok := index[c]; ok {
+			return i
+		}
+		colours = append(colours, c)
+		index[c] = len(colours) // 0 is the default colour
+		return len(colours)
+	}
+
+	markedLines := map[int]bool{}
+	for _, m := range marked {
+		markedLines[m] = true
+	}
+	hl := colourIndex(highlightBackground(style))
+	bg := style.Get(chroma.Background).Background
+	page := 0
+	if bg.IsSet() {
+		page = colourIndex(bg)
+	}
+
+	var body strings.Builder
+	line := 1
+	startLine := func() {
+		if markedLines[line] {
+			fmt.Fprintf(&body, "\\highlight%d ", hl)
+		} else {
+			fmt.Fprintf(&body, "\\highlight%d ", page)
+		}
+	}
+	startLine()
+	for _, l := range chroma.SplitTokensIntoLines(tokens) {
+		for _, t := range l {
+			entry := style.Get(t.Type)
+			var on, off strings.Builder
+			if entry.Colour.IsSet() {
+				fmt.Fprintf(&on, "\\cf%d ", colourIndex(entry.Colour))
+			}
+			if entry.Bold == chroma.Yes {
+				on.WriteString("\\b ")
+				off.WriteString("\\b0 ")
+			}
+			if entry.Italic == chroma.Yes {
+				on.WriteString("\\i ")
+				off.WriteString("\\i0 ")
+			}
+			if entry.Underline == chroma.Yes {
+				on.WriteString("\\ul ")
+				off.WriteString("\\ulnone ")
+			}
+			value, nl := strings.CutSuffix(t.Value, "\n")
+			body.WriteString(on.String())
+			body.WriteString(escapeRTF(value))
+			body.WriteString(off.String())
+			body.WriteString("\\cf0 ")
+			if nl {
+				body.WriteString("\\line\n")
+				line++
+				startLine()
+			}
+		}
+	}
+
+	var doc strings.Builder
+	doc.WriteString("{\\rtf1\\ansi\\deff0{\\fonttbl{\\f0\\fmodern Consolas;}}\n{\\colortbl;")
+	for _, c := range colours {
+		fmt.Fprintf(&doc, "\\red%d\\green%d\\blue%d;", c.Red(), c.Green(), c.Blue())
+	}
+	doc.WriteString("}\n\\f0\\fs20 ")
+	doc.WriteString(body.String())
+	doc.WriteString("}\n")
+	_, err := io.WriteString(w, doc.String())
+	return err
+}
+
+func escapeRTF(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '{' || r == '}':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\t':
+			b.WriteString("\\tab ")
+		case r < 0x80:
+			b.WriteRune(r)
+		case r < 0x10000:
+			// rtf wants a signed 16 bit number and a fallback character
+			fmt.Fprintf(&b, "\\u%d?", int16(r))
+		default:
+			r -= 0x10000
+			fmt.Fprintf(&b, "\\u%d?\\u%d?", int16(0xd800+(r>>10)), int16(0xdc00+(r&0x3ff)))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,467 @@
+// Package highlight prints text for a terminal the way cat does: syntax
+// highlighted with chroma, with GNU cat's -n, -b, -v, -E, -T and -s, bat's
+// decorations (line numbers, git changes, a grid, a header), wrapping, line
+// ranges, binary dumps and Markdown rendered for reading. cat is a command
+// line on top of Render
+package highlight
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/formatters"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"golang.org/x/text/encoding"
+)
+
+type WrapMode int
+
+const (
+	WrapNever     WrapMode = iota
+	WrapCharacter          // anywhere, like a terminal does
+	WrapWord               // at whitespace, keeping the lines indentation
+)
+
+// what binary input turns into
+type BinaryMode int
+
+const (
+	BinaryRaw  BinaryMode = iota // printed as it is, like cat
+	BinaryHex                    // a hexdump -C, coloured by kind of byte
+	BinarySkip                   // a notice with its size
+)
+
+// a format Render can write an input out in as one document
+type DocumentFormat int
+
+const (
+	DocumentNone DocumentFormat = iota
+	DocumentHTML                // standalone, with inline styles
+	DocumentSVG
+	DocumentRTF
+)
+
+// Live is an input that keeps growing while it's printed, like a file
+// being followed. it cant be read ahead, so Render looks into it with these
+type Live interface {
+	io.Reader
+	// Peek reads what comes next without taking it out of the input
+	Peek(b []byte) (int, error)
+	// CountLines counts the lines from where it is to its current end
+	CountLines() (int, error)
+}
+
+// Options says how an input is printed. the zero value prints it like cat
+// with no flags: no colours, decorations or wrapping, and binaries as they
+// are. text that isnt UTF-8 is still taken to be windows-1252 and printed
+// as UTF-8 unless Encoding says otherwise
+type Options struct {
+	Style    *chroma.Style   // the theme, nil for chroma's fallback
+	Color    bool            // write ANSI colours
+	Depth    int             // colours the terminal has: 8, 16, 256 or TrueColor, 0 for TrueColor
+	Language string          // a lexer name, "" to go by the name and what the input starts with
+	Mappings []SyntaxMapping // globs to languages, the last one that matches wins
+	Document DocumentFormat  // a whole document instead of terminal lines
+
+	Number          bool // -n
+	NumberNonblank  bool // -b, wins over Number
+	FirstNumber     int  // lines are numbered from FirstNumber+1, to carry on from another input
+	ShowEnds        bool // -E
+	ShowTabs        bool // -T
+	ShowNonprinting bool // -v
+	SqueezeBlank    bool // -s
+	ShowWhitespace  bool // spaces as ·, tabs as →, trailing whitespace in red
+	TabWidth        int  // 0 for 8
+
+	Wrap       WrapMode
+	Width      int  // columns to wrap at and draw rules across, 0 for 80
+	WrapMarker bool // ↪ in the gutter of continuation lines
+
+	Title       bool    // == [name] == before the input
+	TitleNumber bool    // == [#n: name] ==, n being Index+1
+	Index       int     // where the input is among those printed, 0 for the first
+	Header      bool    // File: name above the input, with its encoding when it isnt UTF-8
+	FileSize    bool    // its size in the header too
+	Grid        bool    // lines around the input and between the gutter and the text
+	Rule        bool    // a line between inputs, above all but the first
+	Diff        bool    // a gutter column for Changes
+	Changes     Changes // what git says changed in each line, nil for nothing
+	DiffOnly    bool    // only the changed lines and DiffContext lines around them
+	DiffContext int
+
+	LineRanges      []LineRange // only these lines, nil for all of them
+	HighlightRanges []LineRange // lines painted with the themes highlight background
+
+	Binary BinaryMode
+
+	Encoding     encoding.Encoding // nil goes by the BOM, or windows-1252 for text that isnt UTF-8
+	EncodingName string            // what Encoding is called in the header
+	Pretty       bool              // reformat JSON, XML, YAML and TOML first
+	Indent       int               // spaces per level for Pretty
+	SortKeys     bool              // Pretty sorts object keys and XML attributes
+	Compact      bool              // Pretty writes documents as tightly as the format allows
+	Markdown     bool              // render Markdown files instead of highlighting their source
+
+	// Warn is told about things that dont stop the input from being
+	// printed, like a language nobody has heard of. nil drops them
+	Warn func(error)
+}
+
+func (o *Options) warn(err error) {
+	if o.Warn != nil {
+		o.Warn(err)
+	}
+}
+
+func (o *Options) numbered() bool {
+	return o.Number || o.NumberNonblank
+}
+
+// Render prints r, called name, to w. it highlights and prints a batch of
+// lines at a time, so a pipe or a followed file shows up as it comes in.
+// the error is from reading r, whatever was read before it is printed, or
+// says why Pretty couldnt parse it, after printing it as it was. the number
+// the last numbered line got comes back for the next input to carry on from
+func Render(w io.Writer, name string, r io.Reader, opts Options) (last int, err error) {
+	o := &opts
+	if o.Style == nil {
+		o.Style = styles.Fallback
+	}
+	if o.Depth == 0 {
+		o.Depth = TrueColor
+	}
+	if o.TabWidth <= 0 {
+		o.TabWidth = 8
+	}
+	if o.Width <= 0 {
+		o.Width = 80
+	}
+	if o.NumberNonblank {
+		o.Number = false
+	}
+	if o.DiffOnly {
+		o.Diff = true
+	}
+	if o.TitleNumber {
+		o.Title = true
+	}
+
+	var size int64 = -1
+	if f, ok := r.(*os.File); ok {
+		if st, err := f.Stat(); err == nil && st.Mode().IsRegular() {
+			size = st.Size()
+		}
+	}
+
+	// binaries are told apart on the bytes as they come, before a guessed
+	// encoding can make them look like text
+	r, encName, bin, err := decodeInput(r, o)
+	if err != nil {
+		return o.FirstNumber, err
+	}
+
+	// the format Pretty found, for input with no name to pick a lexer by
+	var prettyLang string
+	if o.Pretty && !bin {
+		pretty, format, perr := prettyInput(name, r, o)
+		if pretty == nil {
+			return o.FirstNumber, perr
+		}
+		if perr != nil {
+			// the input is printed as it was, then the error is returned
+			// unless reading it went wrong too
+			perr = fmt.Errorf("invalid %s: %w", strings.ToUpper(format), perr)
+			defer func() {
+				if err == nil {
+					err = perr
+				}
+			}()
+		}
+		r, prettyLang = pretty, format
+	}
+
+	if o.Document != DocumentNone {
+		return exportDocument(name, r, w, o)
+	}
+
+	title := name
+	if encName != "" {
+		title += ", " + encName
+	}
+	if o.TitleNumber {
+		fmt.Fprintf(w, "== [#%d: %s] ==\n", o.Index+1, title)
+	} else if o.Title {
+		fmt.Fprintf(w, "== [%s] ==\n", title)
+	}
+
+	formatter := formatters.NoOp
+	if o.Color {
+		formatter = depthFormatter(o.Depth)
+	}
+
+	out := &stickyWriter{w: w}
+	p := &linePrinter{out: out, o: o, lineNum: o.FirstNumber}
+	if o.Color {
+		p.gutter = gutterEscape(o.Style, o.Depth)
+	}
+	if o.Rule && o.Index > 0 {
+		p.rule("─")
+	}
+	if o.Header {
+		p.header = fileHeader(name, size, encName, o)
+	}
+	defer p.end()
+
+	if bin {
+		p.begin()
+		return p.lineNum, catBinary(r, w, o)
+	}
+
+	if o.Markdown && isMarkdown(name, o.Language) {
+		return p.lineNum, renderDocument(r, p)
+	}
+
+	// bounds counted from the end need the line count before anything is
+	// printed. everything is still lexed from the top so tokens come out right
+	total := -1
+	if rangesFromEnd(o.LineRanges) || rangesFromEnd(o.HighlightRanges) {
+		counted, n, err := countInputLines(r)
+		if err != nil {
+			return p.lineNum, err
+		}
+		r, total = counted, n
+		if o.numbered() {
+			p.numWidth = len(strconv.Itoa(p.lineNum+total)) + 1
+		}
+	}
+
+	p.shown = resolveRanges(o.LineRanges, total)
+	p.highlighted = resolveRanges(o.HighlightRanges, total)
+
+	// lines come in from a goroutine so a quiet input (tail -f, a slow pipe)
+	// can be noticed with a timer instead of blocking on the next read
+	stop := make(chan struct{})
+	lines, readErr := readLines(r, stop)
+
+	var lexer chroma.Lexer
+	var pending []string
+	nextFlush := chunkLines
+	eof := false
+
+	idle := time.NewTimer(idleFlush)
+	idle.Stop()
+
+	// highlights pending lines and prints them. with hold set, lines from
+	// the last point where a token might still be open stay pending so the
+	// next batch can finish lexing them
+	flush := func(hold bool) {
+		if len(pending) == 0 {
+			return
+		}
+		text := strings.Join(pending, "")
+		if lexer == nil {
+			// before the end, all there is to go by is the first batch
+			lexer = pickLexer(name, text, eof, o)
+			if lexer == lexers.Fallback && prettyLang != "" {
+				lexer = lexers.Get(prettyLang)
+			}
+		}
+		if p.numWidth == 0 && o.numbered() {
+			if eof {
+				p.numWidth = len(strconv.Itoa(p.lineNum+len(pending))) + 1
+			} else {
+				p.numWidth = streamNumWidth
+			}
+		}
+
+		iterator, err := lexer.Tokenise(nil, text)
+		if err != nil {
+			o.warn(fmt.Errorf("highlight error: %w", err))
+			pending = nil
+			return
+		}
+		tokLines := chroma.SplitTokensIntoLines(iterator.Tokens())
+		cut := len(tokLines)
+		if hold && cut == len(pending) {
+			cut = safeCut(tokLines)
+		}
+		if cut == 0 {
+			// nothing safe yet; wait for twice as much so a long open
+			// token doesnt get re-lexed on every line
+			nextFlush = 2 * len(pending)
+			return
+		}
+
+		var tokens []chroma.Token
+		for _, l := range tokLines[:cut] {
+			tokens = append(tokens, l...)
+		}
+		var buf strings.Builder
+		if err = formatter.Format(&buf, o.Style, chroma.Literator(tokens...)); err != nil {
+			o.warn(fmt.Errorf("format error: %w", err))
+			pending = nil
+			return
+		}
+		for _, line := range strings.SplitAfter(buf.String(), "\n") {
+			if line == "" {
+				continue
+			}
+			p.print(strings.TrimSuffix(line, "\n"))
+		}
+
+		if cut >= len(pending) {
+			pending = nil
+		} else {
+			pending = pending[cut:]
+		}
+		nextFlush = len(pending) + chunkLines
+	}
+
+	for !eof && !p.finished() && out.err == nil {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				eof = true
+				break
+			}
+			if len(pending) == 0 {
+				idle.Reset(idleFlush)
+			}
+			// done before lexing so escapes in the input cant be told
+			// apart from the ones the formatter adds
+			if o.ShowNonprinting {
+				line = showNonprinting(line)
+			}
+			if o.ShowWhitespace {
+				line = markCarriageReturns(line)
+			}
+			pending = append(pending, line)
+			if len(pending) >= nextFlush {
+				flush(len(pending) < maxLookahead)
+			}
+		case <-idle.C:
+			flush(false)
+		}
+	}
+	idle.Stop()
+
+	switch {
+	case out.err != nil:
+		// whatever is printed to cant take any more, theres no point going on
+		close(stop)
+		return p.lineNum, out.err
+	case p.finished():
+		// past the last requested line, the rest doesnt need reading
+		close(stop)
+		return p.lineNum, nil
+	}
+	flush(false)
+	if out.err != nil {
+		return p.lineNum, out.err
+	}
+
+	return p.lineNum, <-readErr
+}
+
+// keeps the first error from w and fails every write after it
+type stickyWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (s *stickyWriter) Write(b []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	n, err := s.w.Write(b)
+	s.err = err
+	return n, err
+}
+
+const (
+	// lines highlighted together. small enough that output shows up
+	// promptly, big enough that the lexer isnt restarted all the time
+	chunkLines = 256
+	// how many lines we hold back waiting for an open string or comment to
+	// close before printing them anyway
+	maxLookahead = 4096
+	// how long input can stay quiet before whatever is pending gets printed
+	idleFlush = 50 * time.Millisecond
+	// gutter width when the line count isnt known up front, same as GNU cat
+	streamNumWidth = 7
+)
+
+// sends r line by line, each with its newline. the error channel gets
+// exactly one value once lines is closed. closing stop makes it give up
+// without sending the rest
+func readLines(r io.Reader, stop <-chan struct{}) (<-chan string, <-chan error) {
+	lines := make(chan string, chunkLines)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(errc)
+		defer close(lines)
+		br := bufio.NewReaderSize(r, 64*1024)
+		for {
+			line, err := br.ReadString('\n')
+			if line != "" {
+				select {
+				case lines <- line:
+				case <-stop:
+					errc <- nil
+					return
+				}
+			}
+			if err == io.EOF {
+				errc <- nil
+				return
+			}
+			if err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+	return lines, errc
+}
+
+// number of leading lines that can be printed without cutting through a
+// token that may continue further down. a line is only a safe place to stop
+// after if it doesnt end inside a string or a block comment, and nothing
+// from the first error token on is trusted since thats usually the lexer
+// choking on something that hasnt been closed yet
+func safeCut(lines [][]chroma.Token) int {
+	limit := len(lines)
+	for i, l := range lines {
+		for _, t := range l {
+			if t.Type == chroma.Error {
+				limit = i
+				break
+			}
+		}
+		if limit != len(lines) {
+			break
+		}
+	}
+	for i := limit; i > 0; i-- {
+		l := lines[i-1]
+		if len(l) > 0 && !openEnded(l[len(l)-1].Type) {
+			return i
+		}
+	}
+	return 0
+}
+
+func openEnded(t chroma.TokenType) bool {
+	switch t {
+	case chroma.CommentSingle, chroma.CommentHashbang, chroma.CommentPreproc, chroma.CommentPreprocFile:
+		return false
+	}
+	return t == chroma.Error || t.InCategory(chroma.Comment) || t.InSubCategory(chroma.String)
+}
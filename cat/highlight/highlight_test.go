@@ -0,0 +1,119 @@
+package highlight
+
+import (
+	"strings"
+	"testing"
+)
+
+func render(t *testing.T, in string, o Options) (string, int) {
+	t.Helper()
+	var b strings.Builder
+	last, err := Render(&b, "test.txt", strings.NewReader(in), o)
+	if err != nil {
+		t.Fatalf("Render(%q): %v", in, err)
+	}
+	return b.String(), last
+}
+
+func lineRanges(t *testing.T, specs ...string) []LineRange {
+	t.Helper()
+	var ranges []LineRange
+	for _, s := range specs {
+		r, err := ParseLineRange(s)
+		if err != nil {
+			t.Fatalf("ParseLineRange(%q): %v", s, err)
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		o    Options
+		want string
+		last int
+	}{
+		{"plain", "a\nb\n", Options{}, "a\nb\n", 0},
+		{"number", "a\n\nb\n", Options{Number: true}, "1 a\n2 \n3 b\n", 3},
+		{"number nonblank", "a\n\nb\n", Options{NumberNonblank: true}, "1 a\n  \n2 b\n", 2},
+		{"nonblank wins", "a\n\nb\n", Options{Number: true, NumberNonblank: true}, "1 a\n  \n2 b\n", 2},
+		{"carried over", "a\nb\n", Options{Number: true, FirstNumber: 9}, "10 a\n11 b\n", 11},
+		{"squeeze blank", "a\n\n\n\nb\n\n", Options{SqueezeBlank: true}, "a\n\nb\n\n", 0},
+		{"squeeze and number", "a\n\n\nb\n", Options{SqueezeBlank: true, Number: true}, "1 a\n2 \n3 b\n", 3},
+		{"show ends and tabs", "a\tb\n", Options{ShowEnds: true, ShowTabs: true}, "a^Ib$\n", 0},
+		{"binary as is", "\x00\x01bin", Options{}, "\x00\x01bin", 0},
+		{"binary skipped", "\x00\x01bin", Options{Binary: BinarySkip}, "<binary file, 5 bytes>\n", 0},
+	}
+	for _, tt := range tests {
+		tt.o.Language = "plaintext"
+		got, last := render(t, tt.in, tt.o)
+		if got != tt.want || last != tt.last {
+			t.Errorf("%s: got %q, %d, want %q, %d", tt.name, got, last, tt.want, tt.last)
+		}
+	}
+}
+
+func TestRenderLineRanges(t *testing.T) {
+	in := "a\nb\nc\nd\ne\n"
+	tests := []struct {
+		name   string
+		ranges []string
+		number bool
+		want   string
+	}{
+		{"from to", []string{"2:3"}, false, "b\nc\n"},
+		{"one bound is up to it", []string{"3"}, false, "a\nb\nc\n"},
+		{"one line", []string{"4:4"}, false, "d\n"},
+		{"l alone is the first line", []string{"l:l"}, false, "a\n"},
+		{"from the end", []string{"-2:"}, false, "d\ne\n"},
+		{"numbered by the input", []string{"2:3"}, true, "2 b\n3 c\n"},
+		{"gaps are marked", []string{"1:1", "4:4"}, false, "a\n--\nd\n"},
+	}
+	for _, tt := range tests {
+		o := Options{Language: "plaintext", LineRanges: lineRanges(t, tt.ranges...), Number: tt.number}
+		if got, _ := render(t, in, o); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRenderWrap(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		mode  WrapMode
+		width int
+		want  string
+	}{
+		{"never", "abcdefghij\n", WrapNever, 4, "abcdefghij\n"},
+		{"character", "abcdefghij klm\n", WrapCharacter, 5, "abcde\nfghij\n klm\n"},
+		{"word", "the quick brown fox\n", WrapWord, 10, "the quick \nbrown fox\n"},
+		{"word keeps the indentation", "  the quick brown fox\n", WrapWord, 10, "  the \n  quick \n  brown \n  fox\n"},
+		{"word splits what doesnt fit", "abcdefghij\n", WrapWord, 4, "abcd\nefgh\nij\n"},
+	}
+	for _, tt := range tests {
+		o := Options{Language: "plaintext", Wrap: tt.mode, Width: tt.width}
+		if got, _ := render(t, tt.in, o); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStripANSI(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain", "plain"},
+		{"\x1b[1;31mred\x1b[0m text", "red text"},
+		{"\x1b[38;2;1;2;3mtrue\x1b[0m", "true"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := StripANSI(tt.in); got != tt.want {
+			t.Errorf("StripANSI(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
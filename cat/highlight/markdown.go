@@ -0,0 +1,569 @@
+package highlight
+
+import (
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+func isMarkdown(name, language string) bool {
+	if language != "" {
+		return strings.EqualFold(language, "markdown") || strings.EqualFold(language, "md")
+	}
+	switch strings.ToLower(filepath.Ext(InnerName(name))) {
+	case ".md", ".markdown", ".mdown", ".mkd", ".mkdn":
+		return true
+	}
+	return false
+}
+
+// a run of text in one style. sgr is the escape to turn it on, "" for none
+type mdSpan struct {
+	text string
+	sgr  string
+}
+
+// lays markdown out for the terminal, width columns wide
+type mdRenderer struct {
+	src   []byte
+	o     *Options
+	width int
+	lines []string
+}
+
+// the document as terminal lines, in colour when o.Color is set. code
+// blocks go through the same lexers and theme as everything else
+func renderMarkdown(src []byte, o *Options, width int) []string {
+	md := goldmark.New(goldmark.WithExtensions(extension.GFM))
+	doc := md.Parser().Parse(text.NewReader(src))
+	m := &mdRenderer{src: src, o: o, width: max(width, 20)}
+	m.blocks(doc, "", "")
+	return m.lines
+}
+
+// escape for the first of the token types the theme has a colour for
+func (m *mdRenderer) color(types ...chroma.TokenType) string {
+	if !m.o.Color {
+		return ""
+	}
+	for _, t := range types {
+		if c := m.o.Style.Get(t).Colour; c.IsSet() {
+			return fgEscape(c, m.o.Depth)
+		}
+	}
+	return ""
+}
+
+func (m *mdRenderer) sgr(codes string) string {
+	if !m.o.Color {
+		return ""
+	}
+	return "\x1b[" + codes + "m"
+}
+
+func (m *mdRenderer) dim() string {
+	if !m.o.Color {
+		return ""
+	}
+	return gutterEscape(m.o.Style, m.o.Depth)
+}
+
+func (m *mdRenderer) add(line string) {
+	m.lines = append(m.lines, line)
+}
+
+// children of n one after another, with a blank line between them unless
+// they're in an item of a tight list
+func (m *mdRenderer) blocks(n ast.Node, first, rest string) {
+	prefix := first
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		if c != n.FirstChild() && !inTightList(n) {
+			m.add(strings.TrimRight(rest, " "))
+		}
+		m.block(c, prefix, rest)
+		prefix = rest
+	}
+}
+
+func inTightList(n ast.Node) bool {
+	if _, ok := n.(*ast.ListItem); !ok {
+		return false
+	}
+	l, ok := n.Parent().(*ast.List)
+	return ok && l.IsTight
+}
+
+// first is put in front of the blocks first line, rest in front of the others
+func (m *mdRenderer) block(n ast.Node, first, rest string) {
+	width := m.width - VisualWidth(rest)
+	switch n := n.(type) {
+	case *ast.Heading:
+		m.heading(n, first, width)
+	case *ast.Paragraph, *ast.TextBlock:
+		m.wrapped(m.inlines(n, ""), first, rest, width)
+	case *ast.ThematicBreak:
+		m.add(first + m.dim() + strings.Repeat("─", width) + m.sgr("0"))
+	case *ast.Blockquote:
+		bar := m.dim() + "│" + m.sgr("0") + " "
+		m.blocks(n, first+bar, rest+bar)
+	case *ast.List:
+		m.list(n, first, rest)
+	case *ast.FencedCodeBlock:
+		m.code(n.Lines(), string(n.Language(m.src)), first, rest)
+	case *ast.CodeBlock:
+		m.code(n.Lines(), "", first, rest)
+	case *ast.HTMLBlock:
+		prefix := first
+		for i := 0; i < n.Lines().Len(); i++ {
+			seg := n.Lines().At(i)
+			m.add(prefix + m.dim() + strings.TrimRight(string(seg.Value(m.src)), "\r\n") + m.sgr("0"))
+			prefix = rest
+		}
+	case *east.Table:
+		m.table(n, first, rest, width)
+	default:
+		m.blocks(n, first, rest)
+	}
+}
+
+func (m *mdRenderer) heading(n *ast.Heading, prefix string, width int) {
+	sgr := m.sgr("1") + m.color(chroma.GenericHeading, chroma.Keyword)
+	if n.Level > 1 {
+		sgr = m.sgr("1") + m.color(chroma.GenericSubheading, chroma.NameFunction)
+	}
+	spans := m.inlines(n, sgr)
+	if n.Level > 2 {
+		spans = append([]mdSpan{{strings.Repeat("#", n.Level) + " ", m.dim()}}, spans...)
+	}
+	lines := m.wrap(spans, width)
+	for _, l := range lines {
+		m.add(prefix + l)
+	}
+
+	if n.Level <= 2 {
+		w := 0
+		for _, l := range lines {
+			w = max(w, VisualWidth(StripANSI(l)))
+		}
+		rule := "═"
+		if n.Level == 2 {
+			rule = "─"
+		}
+		m.add(prefix + m.dim() + strings.Repeat(rule, w) + m.sgr("0"))
+	}
+}
+
+func (m *mdRenderer) list(n *ast.List, first, rest string) {
+	bullets := []string{"•", "◦", "▪"}
+	depth := 0
+	for p := n.Parent(); p != nil; p = p.Parent() {
+		if _, ok := p.(*ast.List); ok {
+			depth++
+		}
+	}
+
+	num := n.Start
+	prefix := first
+	for item := n.FirstChild(); item != nil; item = item.NextSibling() {
+		if item != n.FirstChild() && !n.IsTight {
+			m.add(strings.TrimRight(rest, " "))
+		}
+		marker := bullets[depth%len(bullets)]
+		if n.IsOrdered() {
+			marker = strconv.Itoa(num) + string(n.Marker)
+			num++
+		}
+		// task list items show their box instead of a bullet
+		if box := taskBox(item); box != nil {
+			marker = "☐"
+			if box.IsChecked {
+				marker = "☑"
+			}
+		}
+		pad := strings.Repeat(" ", utf8.RuneCountInString(marker)+1)
+		m.blocks(item, prefix+m.color(chroma.Keyword)+marker+m.sgr("0")+" ", rest+pad)
+		prefix = rest
+	}
+}
+
+func taskBox(item ast.Node) *east.TaskCheckBox {
+	if p := item.FirstChild(); p != nil {
+		if box, ok := p.FirstChild().(*east.TaskCheckBox); ok {
+			return box
+		}
+	}
+	return nil
+}
+
+func (m *mdRenderer) code(lines *text.Segments, lang, first, rest string) {
+	var b strings.Builder
+	for i := 0; i < lines.Len(); i++ {
+		seg := lines.At(i)
+		b.Write(seg.Value(m.src))
+	}
+	source := strings.TrimRight(b.String(), "\n")
+
+	out := source
+	if m.o.Color {
+		lexer := lexers.Get(lang)
+		if lexer == nil {
+			lexer = lexers.Analyse(source)
+		}
+		if lexer == nil {
+			lexer = lexers.Fallback
+		}
+		if it, err := lexer.Tokenise(nil, source); err == nil {
+			var buf strings.Builder
+			if depthFormatter(m.o.Depth).Format(&buf, m.o.Style, it) == nil {
+				out = buf.String()
+			}
+		}
+	}
+
+	bar := m.dim() + "│" + m.sgr("0") + " "
+	prefix := first
+	for _, l := range strings.Split(out, "\n") {
+		m.add(prefix + bar + l)
+		prefix = rest
+	}
+}
+
+// a table sized to fit: columns start out as wide as their widest cell and
+// the widest ones give way until the row fits, wrapping their cells
+func (m *mdRenderer) table(n *east.Table, first, rest string, width int) {
+	var rows [][][]mdSpan
+	for r := n.FirstChild(); r != nil; r = r.NextSibling() {
+		var row [][]mdSpan
+		for c := r.FirstChild(); c != nil; c = c.NextSibling() {
+			sgr := ""
+			if _, header := r.(*east.TableHeader); header {
+				sgr = m.sgr("1")
+			}
+			row = append(row, m.inlines(c, sgr))
+		}
+		rows = append(rows, row)
+	}
+	cols := len(n.Alignments)
+	if cols == 0 || len(rows) == 0 {
+		return
+	}
+
+	widths := make([]int, cols)
+	for _, row := range rows {
+		for i, cell := range row {
+			if i < cols {
+				widths[i] = max(widths[i], spansWidth(cell))
+			}
+		}
+	}
+	// each column has a space either side and a bar between
+	avail := width - 3*(cols-1) - 2
+	for total := sum(widths); total > avail; total-- {
+		widest := 0
+		for i, w := range widths {
+			if w > widths[widest] {
+				widest = i
+			}
+		}
+		if widths[widest] <= 3 {
+			break
+		}
+		widths[widest]--
+	}
+
+	bar := m.dim() + "│" + m.sgr("0")
+	prefix := first
+	for ri, row := range rows {
+		cells := make([][]string, cols)
+		height := 1
+		for i := range cols {
+			if i < len(row) {
+				cells[i] = m.wrap(row[i], widths[i])
+			}
+			height = max(height, len(cells[i]))
+		}
+		for l := range height {
+			var b strings.Builder
+			for i := range cols {
+				if i > 0 {
+					b.WriteString(" " + bar)
+				}
+				var cell string
+				if l < len(cells[i]) {
+					cell = cells[i][l]
+				}
+				b.WriteString(" " + align(cell, widths[i], n.Alignments[i]))
+			}
+			m.add(prefix + strings.TrimRight(b.String(), " "))
+			prefix = rest
+		}
+		if ri == 0 {
+			var b strings.Builder
+			for i, w := range widths {
+				if i > 0 {
+					b.WriteString("┼")
+				}
+				b.WriteString(strings.Repeat("─", w+2))
+			}
+			m.add(prefix + m.dim() + b.String() + m.sgr("0"))
+		}
+	}
+}
+
+func align(cell string, width int, a east.Alignment) string {
+	pad := max(width-VisualWidth(StripANSI(cell)), 0)
+	switch a {
+	case east.AlignRight:
+		return strings.Repeat(" ", pad) + cell
+	case east.AlignCenter:
+		return strings.Repeat(" ", pad/2) + cell + strings.Repeat(" ", pad-pad/2)
+	}
+	return cell + strings.Repeat(" ", pad)
+}
+
+func sum(ns []int) int {
+	total := 0
+	for _, n := range ns {
+		total += n
+	}
+	return total
+}
+
+// the inline children of n as styled spans. sgr is what's on around them
+func (m *mdRenderer) inlines(n ast.Node, sgr string) []mdSpan {
+	var spans []mdSpan
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		switch c := c.(type) {
+		case *ast.Text:
+			spans = append(spans, mdSpan{m.text(c), sgr})
+			switch {
+			case c.HardLineBreak():
+				spans = append(spans, mdSpan{"\n", ""})
+			case c.SoftLineBreak():
+				spans = append(spans, mdSpan{" ", sgr})
+			}
+		case *ast.String:
+			spans = append(spans, mdSpan{string(c.Value), sgr})
+		case *ast.CodeSpan:
+			var b strings.Builder
+			for t := c.FirstChild(); t != nil; t = t.NextSibling() {
+				if t, ok := t.(*ast.Text); ok {
+					b.Write(t.Value(m.src))
+				}
+			}
+			code := b.String()
+			if !m.o.Color {
+				code = "`" + code + "`"
+			}
+			spans = append(spans, mdSpan{code, sgr + m.color(chroma.LiteralString)})
+		case *ast.Emphasis:
+			code := "3"
+			if c.Level > 1 {
+				code = "1"
+			}
+			spans = append(spans, m.inlines(c, sgr+m.sgr(code))...)
+		case *east.Strikethrough:
+			spans = append(spans, m.inlines(c, sgr+m.sgr("9"))...)
+		case *ast.Link:
+			label := m.inlines(c, sgr+m.sgr("4")+m.color(chroma.NameFunction))
+			spans = append(spans, label...)
+			if dest := string(c.Destination); dest != spansText(label) {
+				spans = append(spans, mdSpan{" (" + dest + ")", m.dim()})
+			}
+		case *ast.AutoLink:
+			spans = append(spans, mdSpan{string(c.URL(m.src)), sgr + m.sgr("4") + m.color(chroma.NameFunction)})
+		case *ast.Image:
+			spans = append(spans, mdSpan{"[image: " + spansText(m.inlines(c, "")) + "]", m.dim()})
+		case *ast.RawHTML:
+			for i := 0; i < c.Segments.Len(); i++ {
+				seg := c.Segments.At(i)
+				spans = append(spans, mdSpan{string(seg.Value(m.src)), m.dim()})
+			}
+		case *east.TaskCheckBox:
+			// drawn by the list instead of a bullet
+		default:
+			spans = append(spans, m.inlines(c, sgr)...)
+		}
+	}
+	return spans
+}
+
+// text as the reader sees it, as the HTML renderer would write it: \* is a
+// star and &amp; an ampersand, but \&amp; stays as written
+func (m *mdRenderer) text(t *ast.Text) string {
+	v := t.Value(m.src)
+	if t.IsRaw() {
+		return string(v)
+	}
+	resolve := func(b []byte) []byte {
+		return util.ResolveEntityNames(util.ResolveNumericReferences(b))
+	}
+	var out []byte
+	start := 0
+	for i := 0; i+1 < len(v); i++ {
+		if v[i] == '\\' && util.IsPunct(v[i+1]) {
+			out = append(out, resolve(v[start:i])...)
+			out = append(out, v[i+1])
+			i++
+			start = i + 1
+		}
+	}
+	return string(append(out, resolve(v[start:])...))
+}
+
+func spansText(spans []mdSpan) string {
+	var b strings.Builder
+	for _, s := range spans {
+		b.WriteString(s.text)
+	}
+	return b.String()
+}
+
+func spansWidth(spans []mdSpan) int {
+	return VisualWidth(spansText(spans))
+}
+
+func (m *mdRenderer) wrapped(spans []mdSpan, first, rest string, width int) {
+	prefix := first
+	for _, l := range m.wrap(spans, width) {
+		m.add(prefix + l)
+		prefix = rest
+	}
+}
+
+// greedy word wrap. a word can go across spans ("**bold**,") and keeps each
+// parts style, every piece is closed with a reset so lines stand alone.
+// words longer than the width are cut
+func (m *mdRenderer) wrap(spans []mdSpan, width int) []string {
+	type piece struct {
+		text, sgr string
+	}
+	var lines []string
+	var line strings.Builder
+	lineWidth := 0
+	var word []piece
+	wordWidth := 0
+	space := false // a space is due before the next word
+
+	write := func(p piece) {
+		if p.sgr != "" {
+			line.WriteString(p.sgr + p.text + "\x1b[0m")
+		} else {
+			line.WriteString(p.text)
+		}
+	}
+	breakLine := func() {
+		lines = append(lines, line.String())
+		line.Reset()
+		lineWidth = 0
+		space = false
+	}
+	flushWord := func() {
+		if len(word) == 0 {
+			return
+		}
+		need := wordWidth
+		if space {
+			need++
+		}
+		if lineWidth > 0 && lineWidth+need > width {
+			breakLine()
+		}
+		if space && lineWidth > 0 {
+			line.WriteByte(' ')
+			lineWidth++
+		}
+		for _, p := range word {
+			for lineWidth+VisualWidth(p.text) > width && lineWidth < width {
+				// cut a word too long for a line of its own
+				cut, w := 0, 0
+				for cut < len(p.text) {
+					size, cw := nextCluster(p.text[cut:], lineWidth+w, m.o.TabWidth)
+					if lineWidth+w+cw > width {
+						break
+					}
+					cut, w = cut+size, w+cw
+				}
+				if cut == 0 {
+					break
+				}
+				write(piece{p.text[:cut], p.sgr})
+				breakLine()
+				p.text = p.text[cut:]
+			}
+			write(p)
+			lineWidth += VisualWidth(p.text)
+		}
+		word, wordWidth, space = nil, 0, false
+	}
+
+	for _, s := range spans {
+		if s.text == "\n" {
+			flushWord()
+			breakLine()
+			continue
+		}
+		start := 0
+		for i, r := range s.text {
+			if unicode.IsSpace(r) {
+				if i > start {
+					word = append(word, piece{s.text[start:i], s.sgr})
+					wordWidth += VisualWidth(s.text[start:i])
+				}
+				hadWord := len(word) > 0
+				flushWord()
+				if hadWord || lineWidth > 0 {
+					space = true
+				}
+				start = i + utf8.RuneLen(r)
+			}
+		}
+		if start < len(s.text) {
+			word = append(word, piece{s.text[start:], s.sgr})
+			wordWidth += VisualWidth(s.text[start:])
+		}
+	}
+	flushWord()
+	if line.Len() > 0 || len(lines) == 0 {
+		lines = append(lines, line.String())
+	}
+	return lines
+}
+
+// Markdown: the whole document is laid out first, then printed through p
+// like any other lines, so numbers, ranges and the grid still apply
+func renderDocument(r io.Reader, p *linePrinter) error {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if p.o.numbered() {
+		p.numWidth = len(strconv.Itoa(p.lineNum+countLines(string(src)))) + 1
+	}
+	lines := renderMarkdown(src, p.o, p.o.Width-p.gutterWidth()-p.barWidth())
+
+	if p.o.numbered() {
+		p.numWidth = len(strconv.Itoa(p.lineNum+len(lines))) + 1
+	}
+	p.shown = resolveRanges(p.o.LineRanges, len(lines))
+	p.highlighted = resolveRanges(p.o.HighlightRanges, len(lines))
+	for _, l := range lines {
+		if p.finished() {
+			break
+		}
+		p.print(l)
+	}
+	return nil
+}
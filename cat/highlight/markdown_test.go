@@ -0,0 +1,27 @@
+package highlight
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdownText(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"entities and escapes", "A &amp; B \\*x\\* &#65;\n", "A & B *x* A\n"},
+		{"escaped entity stays", "\\&amp;\n", "&amp;\n"},
+		{"code spans stay as written", "`&amp;`\n", "`&amp;`\n"},
+		{"link labels", "[x &lt; y](u)\n", "x < y (u)\n"},
+		{"table cells", "| a \\| b |\n|---|\n| &euro; |\n", " a | b\n───────\n €\n"},
+	}
+	for _, tt := range tests {
+		var b strings.Builder
+		if _, err := Render(&b, "test.md", strings.NewReader(tt.in), Options{Markdown: true, Width: 80}); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := b.String(); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
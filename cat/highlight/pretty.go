@@ -0,0 +1,532 @@
+package highlight
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"gopkg.in/yaml.v3"
+)
+
+// the language Pretty can reformat text in, from the lexer picked for
+// it, the extension, or when neither knows what it is, what it starts with.
+// "" if none
+func prettyFormat(name, text string, o *Options) string {
+	lexer := pickLexer(name, text, true, o)
+	switch lexer.Config().Name {
+	case "JSON":
+		return "json"
+	case "XML":
+		return "xml"
+	case "YAML":
+		return "yaml"
+	case "TOML":
+		return "toml"
+	}
+	switch strings.ToLower(filepath.Ext(InnerName(name))) {
+	case ".json", ".jsonl", ".ndjson", ".geojson":
+		return "json"
+	case ".xml", ".svg", ".xsd", ".xsl", ".plist":
+		return "xml"
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".toml":
+		return "toml"
+	}
+	if lexer != lexers.Fallback {
+		return ""
+	}
+	switch t := strings.TrimSpace(text); {
+	case strings.HasPrefix(t, "{") || strings.HasPrefix(t, "["):
+		return "json"
+	case strings.HasPrefix(t, "<"):
+		return "xml"
+	}
+	return ""
+}
+
+// reads r whole and gives it back reformatted, with the format it was
+// taken to be. input that isnt a format Pretty knows, or doesnt parse, is
+// given back as it was, the latter with an error saying where it went wrong
+func prettyInput(name string, r io.Reader, o *Options) (io.Reader, string, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, "", err
+	}
+	text := string(raw)
+
+	var out string
+	format := prettyFormat(name, text, o)
+	switch format {
+	case "json":
+		out, err = prettyJSON(text, o)
+	case "xml":
+		out, err = prettyXML(text, o)
+	case "yaml":
+		out, err = prettyYAML(text, o)
+	case "toml":
+		out, err = prettyTOML(text, o)
+	default:
+		return strings.NewReader(text), "", nil
+	}
+	if err != nil {
+		return strings.NewReader(text), format, err
+	}
+	return strings.NewReader(out), format, nil
+}
+
+func prettyIndent(o *Options) string {
+	return strings.Repeat(" ", o.Indent)
+}
+
+// line:column of a byte offset, both 1-based
+func position(text string, offset int) string {
+	offset = min(max(offset, 0), len(text))
+	before := text[:offset]
+	line := strings.Count(before, "\n") + 1
+	col := offset - strings.LastIndexByte(before, '\n')
+	return fmt.Sprintf("%d:%d", line, col)
+}
+
+// one value, or a stream of them like JSON lines. the order of keys is kept
+// unless they're to be sorted
+func prettyJSON(text string, o *Options) (string, error) {
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+	var b strings.Builder
+	for {
+		start := dec.InputOffset()
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var syntax *json.SyntaxError
+			if errors.As(err, &syntax) {
+				return "", fmt.Errorf("%s: %v", position(text, int(syntax.Offset)-1), err)
+			}
+			if err == io.ErrUnexpectedEOF {
+				return "", fmt.Errorf("%s: unexpected end of input", position(text, len(text)))
+			}
+			return "", fmt.Errorf("%s: %v", position(text, int(start)), err)
+		}
+
+		if o.SortKeys {
+			var v any
+			d := json.NewDecoder(bytes.NewReader(raw))
+			d.UseNumber()
+			if err := d.Decode(&v); err != nil {
+				return "", err
+			}
+			var buf bytes.Buffer
+			enc := json.NewEncoder(&buf)
+			enc.SetEscapeHTML(false)
+			if err := enc.Encode(v); err != nil {
+				return "", err
+			}
+			raw = bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+		}
+
+		var buf bytes.Buffer
+		if o.Compact {
+			err = json.Compact(&buf, raw)
+		} else {
+			err = json.Indent(&buf, raw, "", prettyIndent(o))
+		}
+		if err != nil {
+			return "", err
+		}
+		b.Write(buf.Bytes())
+		b.WriteByte('\n')
+	}
+	return b.String(), nil
+}
+
+// walks the raw tokens so prefixes stay as written, an element with only
+// text in it stays on one line, and an empty one is closed with />
+func prettyXML(text string, o *Options) (string, error) {
+	dec := xml.NewDecoder(strings.NewReader(text))
+	dec.Strict = false
+
+	var b strings.Builder
+	var stack []string // elements not closed yet
+	depth := 0
+	open := false   // a start tag is waiting for its > or />
+	inline := false // the current element had text right after its start tag
+	newline := func() {
+		if b.Len() > 0 && !o.Compact {
+			b.WriteString("\n" + strings.Repeat(prettyIndent(o), depth))
+		}
+	}
+	closeOpen := func() {
+		if open {
+			b.WriteString(">")
+			open = false
+		}
+	}
+	qualified := func(n xml.Name) string {
+		if n.Space != "" {
+			return n.Space + ":" + n.Local
+		}
+		return n.Local
+	}
+
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			line, col := dec.InputPos()
+			return "", fmt.Errorf("%d:%d: %v", line, col, err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			closeOpen()
+			newline()
+			b.WriteString("<" + qualified(t.Name))
+			attrs := t.Attr
+			if o.SortKeys {
+				attrs = append([]xml.Attr(nil), attrs...)
+				sort.SliceStable(attrs, func(i, j int) bool {
+					return qualified(attrs[i].Name) < qualified(attrs[j].Name)
+				})
+			}
+			for _, a := range attrs {
+				b.WriteString(" " + qualified(a.Name) + "=\"")
+				xml.EscapeText(&b, []byte(a.Value))
+				b.WriteString("\"")
+			}
+			open = true
+			inline = false
+			stack = append(stack, qualified(t.Name))
+			depth++
+		case xml.EndElement:
+			if len(stack) == 0 || stack[len(stack)-1] != qualified(t.Name) {
+				line, col := dec.InputPos()
+				return "", fmt.Errorf("%d:%d: unexpected </%s>", line, col, qualified(t.Name))
+			}
+			depth--
+			stack = stack[:len(stack)-1]
+			switch {
+			case open:
+				b.WriteString("/>")
+				open = false
+			case inline:
+				b.WriteString("</" + qualified(t.Name) + ">")
+			default:
+				newline()
+				b.WriteString("</" + qualified(t.Name) + ">")
+			}
+			inline = false
+		case xml.CharData:
+			s := strings.TrimSpace(string(t))
+			if s == "" {
+				continue
+			}
+			if open {
+				closeOpen()
+				inline = true
+			} else {
+				newline()
+			}
+			xml.EscapeText(&b, []byte(s))
+		case xml.Comment:
+			closeOpen()
+			newline()
+			b.WriteString("<!--" + string(t) + "-->")
+		case xml.ProcInst:
+			closeOpen()
+			newline()
+			b.WriteString("<?" + t.Target)
+			if len(t.Inst) > 0 {
+				b.WriteString(" " + string(t.Inst))
+			}
+			b.WriteString("?>")
+		case xml.Directive:
+			closeOpen()
+			newline()
+			b.WriteString("<!" + string(t) + ">")
+		}
+	}
+	if len(stack) > 0 {
+		line, col := dec.InputPos()
+		return "", fmt.Errorf("%d:%d: unexpected end of input inside <%s>", line, col, stack[len(stack)-1])
+	}
+	b.WriteString("\n")
+	return b.String(), nil
+}
+
+// through yaml.v3's node tree, which keeps comments and the order of keys.
+// compact writes everything in flow style
+func prettyYAML(text string, o *Options) (string, error) {
+	dec := yaml.NewDecoder(strings.NewReader(text))
+	var b strings.Builder
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(max(o.Indent, 1))
+	for {
+		var doc yaml.Node
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", yamlError(err)
+		}
+		if o.SortKeys || o.Compact {
+			restyleYAML(&doc, o)
+		}
+		if err := enc.Encode(&doc); err != nil {
+			return "", err
+		}
+	}
+	if err := enc.Close(); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+var yamlLine = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+// yaml.v3 says "yaml: line 3: ...", this makes it 3:1 like the others
+func yamlError(err error) error {
+	if m := yamlLine.FindStringSubmatch(err.Error()); m != nil {
+		return fmt.Errorf("%s:1: %s", m[1], m[2])
+	}
+	return err
+}
+
+func restyleYAML(n *yaml.Node, o *Options) {
+	if o.Compact && (n.Kind == yaml.MappingNode || n.Kind == yaml.SequenceNode) {
+		n.Style = yaml.FlowStyle
+	}
+	if o.SortKeys && n.Kind == yaml.MappingNode {
+		type pair struct{ k, v *yaml.Node }
+		pairs := make([]pair, 0, len(n.Content)/2)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			pairs = append(pairs, pair{n.Content[i], n.Content[i+1]})
+		}
+		sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].k.Value < pairs[j].k.Value })
+		for i, p := range pairs {
+			n.Content[2*i], n.Content[2*i+1] = p.k, p.v
+		}
+	}
+	for _, c := range n.Content {
+		restyleYAML(c, o)
+	}
+}
+
+// the decoder gives a map, so the order of keys comes from its metadata
+// and the document is written back out here. comments are lost
+func prettyTOML(text string, o *Options) (string, error) {
+	var v map[string]any
+	md, err := toml.Decode(text, &v)
+	if err != nil {
+		var perr toml.ParseError
+		if errors.As(err, &perr) {
+			return "", fmt.Errorf("%d:%d: %s", perr.Position.Line, perr.Position.Col, perr.Message)
+		}
+		return "", err
+	}
+
+	// Keys gives every key in document order, a [[table]] once per element
+	// with the keys after it belonging to that element
+	order := &tomlOrder{}
+	for _, key := range md.Keys() {
+		n := order
+		for i, part := range key {
+			n = n.child(part)
+			if i == len(key)-1 && md.Type(key...) == "ArrayHash" {
+				n.elems = append(n.elems, &tomlOrder{})
+			}
+			if len(n.elems) > 0 {
+				n = n.elems[len(n.elems)-1]
+			}
+		}
+	}
+
+	var b strings.Builder
+	if err := writeTOMLTable(&b, nil, v, order, false, o); err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(b.String(), "\n"), nil
+}
+
+type tomlOrder struct {
+	keys  []string
+	sub   map[string]*tomlOrder
+	elems []*tomlOrder // for an array of tables, one per element
+}
+
+func (t *tomlOrder) child(key string) *tomlOrder {
+	if t.sub == nil {
+		t.sub = map[string]*tomlOrder{}
+	}
+	if t.sub[key] == nil {
+		t.sub[key] = &tomlOrder{}
+		t.keys = append(t.keys, key)
+	}
+	return t.sub[key]
+}
+
+// a tables own values under its header, then its tables. a table with
+// only tables in it gets no header of its own, like the encoder does it,
+// an element of an array of tables always does
+func writeTOMLTable(b *strings.Builder, path []string, m map[string]any, order *tomlOrder, elem bool, o *Options) error {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if !o.SortKeys && order != nil {
+		inOrder := slices.Clone(order.keys)
+		for _, k := range keys {
+			if !slices.Contains(order.keys, k) {
+				inOrder = append(inOrder, k)
+			}
+		}
+		keys = inOrder
+	}
+
+	indent := ""
+	if !o.Compact && len(path) > 1 {
+		indent = strings.Repeat(prettyIndent(o), len(path)-1)
+	}
+	var values strings.Builder
+	var tables []string
+	for _, k := range keys {
+		v, ok := m[k]
+		if !ok {
+			continue
+		}
+		if _, ok := tomlTables(v); ok {
+			tables = append(tables, k)
+			continue
+		}
+		val, err := tomlValue(v)
+		if err != nil {
+			return err
+		}
+		values.WriteString(indent + tomlKey(k) + " = " + val + "\n")
+	}
+	switch {
+	case elem:
+		b.WriteString("\n" + indent + "[[" + tomlPath(path) + "]]\n")
+	case len(path) > 0 && (values.Len() > 0 || len(tables) == 0):
+		b.WriteString("\n" + indent + "[" + tomlPath(path) + "]\n")
+	}
+	b.WriteString(values.String())
+
+	for _, k := range tables {
+		sub := append(slices.Clip(path), k)
+		var next *tomlOrder
+		if order != nil {
+			next = order.sub[k]
+		}
+		if v, ok := m[k].(map[string]any); ok {
+			if err := writeTOMLTable(b, sub, v, next, false, o); err != nil {
+				return err
+			}
+			continue
+		}
+		elems, _ := tomlTables(m[k])
+		for i, elem := range elems {
+			var elemOrder *tomlOrder
+			if next != nil && i < len(next.elems) {
+				elemOrder = next.elems[i]
+			}
+			if err := writeTOMLTable(b, sub, elem, elemOrder, true, o); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// a table, or an array with only tables in it, as the elements of an
+// array of tables. the decoder gives []any for inline ones
+func tomlTables(v any) ([]map[string]any, bool) {
+	switch v := v.(type) {
+	case map[string]any:
+		return []map[string]any{v}, true
+	case []map[string]any:
+		return v, true
+	case []any:
+		if len(v) == 0 {
+			return nil, false
+		}
+		elems := make([]map[string]any, len(v))
+		for i, e := range v {
+			m, ok := e.(map[string]any)
+			if !ok {
+				return nil, false
+			}
+			elems[i] = m
+		}
+		return elems, true
+	}
+	return nil, false
+}
+
+var tomlBareKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func tomlKey(k string) string {
+	if tomlBareKey.MatchString(k) {
+		return k
+	}
+	q, _ := tomlValue(k)
+	return q
+}
+
+func tomlPath(path []string) string {
+	keys := make([]string, len(path))
+	for i, k := range path {
+		keys[i] = tomlKey(k)
+	}
+	return strings.Join(keys, ".")
+}
+
+// one value as the encoder writes it, which is the part after "v = ".
+// tables left in arrays with other things are written inline
+func tomlValue(v any) (string, error) {
+	switch v := v.(type) {
+	case map[string]any:
+		keys := slices.Sorted(maps.Keys(v))
+		for i, k := range keys {
+			val, err := tomlValue(v[k])
+			if err != nil {
+				return "", err
+			}
+			keys[i] = tomlKey(k) + " = " + val
+		}
+		return "{" + strings.Join(keys, ", ") + "}", nil
+	case []any:
+		elems := make([]string, len(v))
+		for i, e := range v {
+			val, err := tomlValue(e)
+			if err != nil {
+				return "", err
+			}
+			elems[i] = val
+		}
+		return "[" + strings.Join(elems, ", ") + "]", nil
+	}
+	var b strings.Builder
+	if err := toml.NewEncoder(&b).Encode(map[string]any{"v": v}); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(b.String(), "v = "), "\n"), nil
+}
@@ -0,0 +1,39 @@
+package highlight
+
+import "testing"
+
+func TestPrettyFormat(t *testing.T) {
+	tests := []struct {
+		name, text, want string
+	}{
+		{"x.json", "[1]", "json"},
+		{"x.toml", "a = 1\n", "toml"},
+		{"noext", "  {\"a\": 1}", "json"},
+		{"noext", "<a/>", "xml"},
+		{"c.ini", "[core]\nx = 1\n", ""},
+		{"p.html", "<p>a<br>b</p>\n", ""},
+	}
+	for _, tt := range tests {
+		if got := prettyFormat(tt.name, tt.text, &Options{}); got != tt.want {
+			t.Errorf("prettyFormat(%q, %q) = %q, want %q", tt.name, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestPrettyTOML(t *testing.T) {
+	in := "z = 1\na = 2\n[t]\ny = 1\nx = [1, 2]\n[[t.e]]\nb = 1\n[[t.e]]\na = 1\n"
+	tests := []struct {
+		name string
+		o    Options
+		want string
+	}{
+		{"document order", Options{Indent: 2}, "z = 1\na = 2\n\n[t]\ny = 1\nx = [1, 2]\n\n  [[t.e]]\n  b = 1\n\n  [[t.e]]\n  a = 1\n"},
+		{"sorted", Options{Indent: 2, SortKeys: true}, "a = 2\nz = 1\n\n[t]\nx = [1, 2]\ny = 1\n\n  [[t.e]]\n  b = 1\n\n  [[t.e]]\n  a = 1\n"},
+	}
+	for _, tt := range tests {
+		got, err := prettyTOML(in, &tt.o)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
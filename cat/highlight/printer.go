@@ -0,0 +1,302 @@
+package highlight
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// prints the highlighted input a line at a time with the gutter, wrapping
+// and everything else Options asks for
+type linePrinter struct {
+	out      io.Writer
+	o        *Options
+	numWidth int
+	gutter   string // escape for numbers and other decorations
+
+	shown       []span // LineRanges, nil means everything
+	highlighted []span
+
+	lineNum   int
+	srcLine   int // lines seen so far, printed or not
+	prevBlank bool
+	printed   bool
+	skipped   bool // DiffOnly left something out since the last line
+
+	header  []string // Header lines, printed before the first line
+	started bool
+}
+
+func (p *linePrinter) print(line string) {
+	src := p.srcLine
+	p.srcLine++
+	isBlank := strings.TrimSpace(StripANSI(line)) == ""
+
+	if p.o.SqueezeBlank && isBlank && p.prevBlank {
+		return
+	}
+	p.prevBlank = isBlank
+
+	printNum := p.o.Number || (p.o.NumberNonblank && !isBlank)
+	if printNum {
+		p.lineNum++
+		if w := len(strconv.Itoa(p.lineNum)) + 1; w > p.numWidth {
+			p.numWidth = w
+		}
+	}
+
+	if !p.visible(src) {
+		p.skipped = true
+		return
+	}
+	if p.skipped && p.printed {
+		p.begin()
+		if p.o.Color {
+			fmt.Fprintf(p.out, "%s--\x1b[0m\n", p.gutter)
+		} else {
+			fmt.Fprintln(p.out, "--")
+		}
+	}
+	p.skipped = false
+	p.printed = true
+	p.begin()
+
+	displayLine := line
+	if p.o.ShowTabs {
+		displayLine = strings.ReplaceAll(displayLine, "\t", "^I")
+	}
+	if p.o.ShowWhitespace {
+		displayLine = showWhitespace(displayLine, p.o.TabWidth, p.o.Color, p.gutter)
+	}
+	if p.o.ShowEnds {
+		displayLine = appendBeforeTrailingReset(displayLine, "$")
+	}
+
+	gutterWidth := p.gutterWidth() + p.barWidth()
+
+	var outputLines []string
+	switch {
+	case gutterWidth >= p.o.Width:
+		outputLines = []string{displayLine}
+	case p.o.Wrap == WrapWord:
+		outputLines = wrapLineWords(displayLine, p.o.Width-gutterWidth, p.o.TabWidth)
+	case p.o.Wrap == WrapCharacter:
+		outputLines = WrapLine(displayLine, p.o.Width-gutterWidth, p.o.TabWidth)
+	default:
+		outputLines = []string{displayLine}
+	}
+
+	indent := strings.Repeat(" ", p.numWidth)
+
+	var gutter strings.Builder
+	for j, l := range outputLines {
+		gutter.Reset()
+		switch {
+		case printNum && j == 0:
+			if p.o.Color {
+				fmt.Fprintf(&gutter, "%s%*d\x1b[0m ", p.gutter, p.numWidth-1, p.lineNum)
+			} else {
+				fmt.Fprintf(&gutter, "%*d ", p.numWidth-1, p.lineNum)
+			}
+		case j > 0 && p.wrapMarker():
+			pad := strings.Repeat(" ", max(p.numWidth-2, 0))
+			if p.o.Color {
+				fmt.Fprintf(&gutter, "%s%s↪\x1b[0m ", pad, p.gutter)
+			} else {
+				gutter.WriteString(pad + "↪ ")
+			}
+		case p.numWidth > 0:
+			gutter.WriteString(indent)
+		case p.wrapMarker():
+			gutter.WriteString("  ")
+		}
+		if p.o.Diff {
+			var kind byte
+			if j == 0 {
+				kind = p.o.Changes.at(src)
+			}
+			writeChangeMarker(&gutter, kind, p.o.Color)
+		}
+		p.writeBar(&gutter)
+		if p.o.Color && inSpans(p.highlighted, src+1) {
+			l = highlightLine(l, p.o.Style, p.o.Depth)
+		}
+		fmt.Fprintf(p.out, "%s%s\n", gutter.String(), l)
+	}
+}
+
+// src is the 0-based line in the input
+func (p *linePrinter) visible(src int) bool {
+	if p.o.DiffOnly && p.o.Changes != nil && !p.o.Changes.near(src, p.o.DiffContext) {
+		return false
+	}
+	return p.shown == nil || inSpans(p.shown, src+1)
+}
+
+// whether every line LineRanges asks for has gone by
+func (p *linePrinter) finished() bool {
+	return p.shown != nil && p.srcLine >= spansEnd(p.shown)
+}
+
+// WrapLine cuts line into pieces at most width columns wide, anywhere but
+// in the middle of an escape or a grapheme cluster
+func WrapLine(line string, width, tabWidth int) []string {
+	if width <= 0 {
+		return []string{line}
+	}
+
+	var result []string
+	var cur strings.Builder
+	visCol := 0
+
+	i := 0
+	for i < len(line) {
+		if line[i] == '\x1b' && i+1 < len(line) && line[i+1] == '[' {
+			j := i + 2
+			for j < len(line) && (line[j] < 0x40 || line[j] > 0x7e) {
+				j++
+			}
+			if j < len(line) {
+				j++
+			}
+			cur.WriteString(line[i:j])
+			i = j
+			continue
+		}
+
+		size, rw := nextCluster(line[i:], visCol, tabWidth)
+
+		if visCol+rw > width && visCol > 0 {
+			result = append(result, cur.String())
+			cur.Reset()
+			visCol = 0
+		}
+
+		cur.WriteString(line[i : i+size])
+		visCol += rw
+		i += size
+	}
+
+	if cur.Len() > 0 {
+		result = append(result, cur.String())
+	}
+	if len(result) == 0 {
+		result = []string{""}
+	}
+	return result
+}
+
+// WrapWord: breaks after whitespace where it can and between characters
+// where a word is wider than the line. continuation lines get the lines
+// own indentation (unless it would take up half the width) and the colours
+// that were on where it broke, so each line stands on its own
+func wrapLineWords(line string, width, tabWidth int) []string {
+	if width <= 0 {
+		return []string{line}
+	}
+
+	type atom struct {
+		s     string
+		w     int
+		space bool
+		esc   bool
+		kept  bool // an escape put back at the start of a continuation line
+	}
+	var atoms []atom
+	col := 0
+	for i := 0; i < len(line); {
+		if n := escapeLen(line[i:]); n > 0 {
+			atoms = append(atoms, atom{s: line[i : i+n], esc: true})
+			i += n
+			continue
+		}
+		size, w := nextCluster(line[i:], col, tabWidth)
+		g := line[i : i+size]
+		atoms = append(atoms, atom{s: g, w: w, space: g == " " || g == "\t"})
+		col += w
+		i += size
+	}
+
+	// the leading whitespace, escapes in it included
+	lead := 0
+	indent, indentW := "", 0
+	for lead < len(atoms) && (atoms[lead].esc || atoms[lead].space) {
+		if !atoms[lead].esc {
+			indent += atoms[lead].s
+			indentW += atoms[lead].w
+		}
+		lead++
+	}
+	if indentW*2 > width {
+		indent, indentW = "", 0
+	}
+
+	var result []string
+	var cur []atom
+	curW := 0
+	start := lead       // atoms before this are indentation, not a place to break
+	breakAt := -1       // just after the last whitespace in cur
+	var active []string // escapes since the last reset, up to what's been emitted
+
+	emit := func(upTo int) []atom {
+		var b strings.Builder
+		for _, a := range cur[:upTo] {
+			b.WriteString(a.s)
+			if a.esc && !a.kept {
+				if a.s == "\x1b[0m" {
+					active = active[:0]
+				} else {
+					active = append(active, a.s)
+				}
+			}
+		}
+		result = append(result, b.String())
+		rest := cur[upTo:]
+		// drop the whitespace the line broke at
+		for len(rest) > 0 && rest[0].space {
+			rest = rest[1:]
+		}
+		next := []atom{{s: indent, w: indentW}}
+		for _, e := range active {
+			next = append(next, atom{s: e, esc: true, kept: true})
+		}
+		start = len(next)
+		return append(next, rest...)
+	}
+	atomsWidth := func(as []atom) int {
+		w := 0
+		for _, a := range as {
+			w += a.w
+		}
+		return w
+	}
+
+	for _, a := range atoms {
+		if !a.esc && curW+a.w > width && curW > indentW {
+			if breakAt > start && breakAt < len(cur) {
+				cur = emit(breakAt)
+			} else {
+				cur = emit(len(cur))
+			}
+			curW = atomsWidth(cur)
+			breakAt = -1
+			if a.space && len(cur) == start {
+				continue
+			}
+		}
+		cur = append(cur, a)
+		curW += a.w
+		if a.space && len(cur) > start {
+			breakAt = len(cur)
+		}
+	}
+	if len(cur) > 0 || len(result) == 0 {
+		var b strings.Builder
+		for _, a := range cur {
+			b.WriteString(a.s)
+		}
+		result = append(result, b.String())
+	}
+	return result
+}
@@ -0,0 +1,215 @@
+package highlight
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+)
+
+// LineBound is one end of a LineRange, in slice's bound syntax (n, nl, or
+// l on its own for 1) plus -n for the nth line from the end. open ends are
+// empty
+type LineBound struct {
+	N       int
+	FromEnd bool
+	IsEnd   bool
+}
+
+// LineRange is a run of lines, like --line-range 10:40
+type LineRange struct {
+	Lower, Upper LineBound
+}
+
+// 1-based and inclusive, upper is math.MaxInt for open ranges
+type span struct {
+	from, to int
+}
+
+func parseLineBound(s string) (LineBound, error) {
+	if s == "" {
+		return LineBound{IsEnd: true}, nil
+	}
+
+	var b LineBound
+	if strings.HasPrefix(s, "-") {
+		b.FromEnd = true
+		s = s[1:]
+	}
+
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	if i == len(s) && i == 0 {
+		return LineBound{}, fmt.Errorf("missing line number in %q", s)
+	}
+	// like slice, a suffix without a number is the first one
+	n := 1
+	if i > 0 {
+		var err error
+		if n, err = strconv.Atoi(s[:i]); err != nil {
+			return LineBound{}, fmt.Errorf("invalid number in %q: %w", s, err)
+		}
+	}
+	if n == 0 {
+		return LineBound{}, fmt.Errorf("lines are counted from 1, got %q", s)
+	}
+	b.N = n
+
+	switch suffix := s[i:]; suffix {
+	case "", "l":
+		return b, nil
+	case "c", "w", "b":
+		return LineBound{}, fmt.Errorf("only line bounds work here, got %q", s)
+	default:
+		return LineBound{}, fmt.Errorf("unknown suffix %q", suffix)
+	}
+}
+
+// ParseLineRange reads lower:upper, either side may be left open. a single
+// bound is from the first line to it, as slice's file[10l] is lines 1 to
+// 10. n:n is just line n
+func ParseLineRange(s string) (LineRange, error) {
+	lower, upper, ok := strings.Cut(s, ":")
+	if !ok {
+		b, err := parseLineBound(s)
+		if err != nil {
+			return LineRange{}, err
+		}
+		if b.IsEnd {
+			return LineRange{}, fmt.Errorf("empty line range")
+		}
+		return LineRange{LineBound{N: 1}, b}, nil
+	}
+
+	var r LineRange
+	var err error
+	if r.Lower, err = parseLineBound(lower); err != nil {
+		return LineRange{}, fmt.Errorf("lower bound: %w", err)
+	}
+	if r.Upper, err = parseLineBound(upper); err != nil {
+		return LineRange{}, fmt.Errorf("upper bound: %w", err)
+	}
+	return r, nil
+}
+
+func rangesFromEnd(ranges []LineRange) bool {
+	for _, r := range ranges {
+		if r.Lower.FromEnd || r.Upper.FromEnd {
+			return true
+		}
+	}
+	return false
+}
+
+// total only matters for bounds counted from the end. no ranges give nil,
+// which means no restriction
+func resolveRanges(ranges []LineRange, total int) []span {
+	if len(ranges) == 0 {
+		return nil
+	}
+	resolve := func(b LineBound, open int) int {
+		switch {
+		case b.IsEnd:
+			return open
+		case b.FromEnd:
+			return total - b.N + 1
+		default:
+			return b.N
+		}
+	}
+
+	spans := make([]span, 0, len(ranges))
+	for _, r := range ranges {
+		spans = append(spans, span{resolve(r.Lower, 1), resolve(r.Upper, math.MaxInt)})
+	}
+	return spans
+}
+
+// line is 1-based
+func inSpans(spans []span, line int) bool {
+	for _, s := range spans {
+		if line >= s.from && line <= s.to {
+			return true
+		}
+	}
+	return false
+}
+
+// the last line any of the spans can reach
+func spansEnd(spans []span) int {
+	end := 0
+	for _, s := range spans {
+		end = max(end, s.to)
+	}
+	return end
+}
+
+// counts the lines of r for ranges counted from the end. regular files are
+// read twice, anything else is kept in memory, since theres no end to count
+// from otherwise. a Live input counts what it has so far
+func countInputLines(r io.Reader) (io.Reader, int, error) {
+	if l, ok := r.(Live); ok {
+		n, err := l.CountLines()
+		return l, n, err
+	}
+	if f, ok := r.(*os.File); ok {
+		if st, err := f.Stat(); err == nil && st.Mode().IsRegular() {
+			if pos, err := f.Seek(0, io.SeekCurrent); err == nil {
+				n, err := CountLines(bufio.NewReader(f))
+				if err != nil {
+					return nil, 0, err
+				}
+				if _, err = f.Seek(pos, io.SeekStart); err != nil {
+					return nil, 0, err
+				}
+				return f, n, nil
+			}
+		}
+	}
+
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	return bytes.NewReader(raw), countLines(string(raw)), nil
+}
+
+// CountLines counts the lines in r, a last one without a newline included
+func CountLines(r io.Reader) (int, error) {
+	buf := make([]byte, 64*1024)
+	n := 0
+	last := byte('\n')
+	for {
+		k, err := r.Read(buf)
+		if k > 0 {
+			n += bytes.Count(buf[:k], []byte{'\n'})
+			last = buf[k-1]
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if last != '\n' {
+		n++
+	}
+	return n, nil
+}
+
+// paints a whole output line with the themes line highlight background.
+// every reset in the line would drop the background, so it's put back after
+// each one, and the erase at the end fills the rest of the row
+func highlightLine(line string, style *chroma.Style, depth int) string {
+	seq := bgEscape(highlightBackground(style), depth)
+	return seq + strings.ReplaceAll(line, "\x1b[0m", "\x1b[0m"+seq) + "\x1b[K\x1b[0m"
+}
@@ -0,0 +1,336 @@
+package highlight
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/lexers"
+)
+
+// Lexer picks the lexer Render would for an input called name that starts
+// with sample: opts.Language, then the last of opts.Mappings that matches
+// the name, then a modeline in the input, then what chroma thinks of the
+// name, then the #! line, then what chroma thinks of the content. it's
+// never nil
+func Lexer(name, sample string, opts Options) chroma.Lexer {
+	return pickLexer(name, sample, false, &opts)
+}
+
+// whole says sample is all of the input, not just its start
+func pickLexer(name, sample string, whole bool, o *Options) chroma.Lexer {
+	var lexer chroma.Lexer
+	if o.Language != "" {
+		lexer = lexers.Get(o.Language)
+		if lexer == nil {
+			o.warn(fmt.Errorf("unknown language %q, falling back to autodetect", o.Language))
+		}
+	}
+	if lexer == nil {
+		lexer = mappedLexer(name, o.Mappings)
+	}
+	if inner := InnerName(name); lexer == nil && inner != name {
+		lexer = mappedLexer(inner, o.Mappings)
+	}
+	if lexer == nil {
+		lexer = hintedLexer(InnerName(name), sample, whole)
+	}
+	if lexer == nil {
+		lexer = lexers.Analyse(sample)
+	}
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	return lexer
+}
+
+// what the input says about itself and what its name says: a vim modeline
+// in the first five lines or, when sample is the whole input, the last
+// five (ft=, filetype=, syntax=), an emacs -*- mode -*- line, which has to
+// be first or right after a #!, then the name, and failing those the
+// interpreter in the #! line. modelines win as editors let them override
+// everything, the name beats the #! as x.ts run by node is still TypeScript
+func hintedLexer(name, sample string, whole bool) chroma.Lexer {
+	lines := strings.SplitN(sample, "\n", 3)
+	if len(lines) > 2 {
+		lines = lines[:2]
+	}
+
+	all := strings.Split(strings.TrimRight(sample, "\n"), "\n")
+	edges := all
+	switch {
+	case len(all) > 10 && whole:
+		edges = append(all[:5:5], all[len(all)-5:]...)
+	case len(all) > 5 && !whole:
+		edges = all[:5]
+	}
+	for _, l := range edges {
+		m := vimModeline.FindStringSubmatch(l)
+		if m == nil {
+			continue
+		}
+		if ft := vimFiletype.FindStringSubmatch(m[1]); ft != nil {
+			if lexer := hintLexer(ft[1]); lexer != nil {
+				return lexer
+			}
+		}
+	}
+
+	if !strings.HasPrefix(lines[0], "#!") {
+		lines = lines[:1]
+	}
+	for _, l := range lines {
+		m := emacsModeline.FindStringSubmatch(l)
+		if m == nil {
+			continue
+		}
+		mode := strings.TrimSpace(m[1])
+		if strings.Contains(mode, ":") {
+			mm := emacsMode.FindStringSubmatch(mode)
+			if mm == nil {
+				continue
+			}
+			mode = mm[1]
+		}
+		if lexer := hintLexer(strings.TrimSuffix(strings.ToLower(mode), "-mode")); lexer != nil {
+			return lexer
+		}
+	}
+
+	if lexer := lexers.Match(name); lexer != nil {
+		return lexer
+	}
+	if l, ok := strings.CutPrefix(lines[0], "#!"); ok {
+		if lexer := interpreterLexer(l); lexer != nil {
+			return lexer
+		}
+	}
+	return nil
+}
+
+var (
+	vimModeline   = regexp.MustCompile(`(?:^|\s)(?:vim?\d*|Vim|ex):\s*(?:set?\s+)?(.*)`)
+	vimFiletype   = regexp.MustCompile(`(?:^|[\s:])(?:ft|filetype|syn|syntax)=([\w+.-]+)`)
+	emacsModeline = regexp.MustCompile(`-\*-(.*?)-\*-`)
+	emacsMode     = regexp.MustCompile(`(?:^|;)\s*mode:\s*([\w+.-]+)`)
+)
+
+// the lexer for the rest of a #! line, which can be a path to the
+// interpreter or env with options and variables before it
+func interpreterLexer(line string) chroma.Lexer {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+	interp := filepath.Base(fields[0])
+	if interp == "env" {
+		interp = ""
+		for _, f := range fields[1:] {
+			if !strings.HasPrefix(f, "-") && !strings.Contains(f, "=") {
+				interp = filepath.Base(f)
+				break
+			}
+		}
+	}
+	if interp == "" {
+		return nil
+	}
+	if lexer := hintLexer(interp); lexer != nil {
+		return lexer
+	}
+	// python3.12, php8
+	return hintLexer(strings.TrimRight(interp, "0123456789."))
+}
+
+// interpreters and modes chroma doesnt know by that name
+var hintAliases = map[string]string{
+	"node":         "javascript",
+	"nodejs":       "javascript",
+	"deno":         "typescript",
+	"bun":          "typescript",
+	"rscript":      "r",
+	"dash":         "bash",
+	"ash":          "bash",
+	"shell-script": "bash",
+	"tclsh":        "tcl",
+	"wish":         "tcl",
+	"expect":       "tcl",
+	"guile":        "scheme",
+	"sbcl":         "common lisp",
+	"clisp":        "common lisp",
+	"runghc":       "haskell",
+	"runhaskell":   "haskell",
+	"cperl":        "perl",
+}
+
+func hintLexer(name string) chroma.Lexer {
+	name = strings.ToLower(name)
+	if alias, ok := hintAliases[name]; ok {
+		name = alias
+	}
+	if name == "" {
+		return nil
+	}
+	return lexers.Get(name)
+}
+
+// SyntaxMapping sends files matching a glob to a lexer, like --map-syntax
+type SyntaxMapping struct {
+	glob     string
+	re       *regexp.Regexp
+	fullPath bool // the glob has a slash, so it's matched against the whole path
+	lexer    chroma.Lexer
+}
+
+// ParseSyntaxMapping reads glob:language, split at the last colon so the
+// glob can have some
+func ParseSyntaxMapping(s string) (SyntaxMapping, error) {
+	i := strings.LastIndex(s, ":")
+	if i <= 0 || i == len(s)-1 {
+		return SyntaxMapping{}, fmt.Errorf("want glob:language")
+	}
+	glob, language := s[:i], s[i+1:]
+
+	lexer := lexers.Get(language)
+	if lexer == nil {
+		return SyntaxMapping{}, fmt.Errorf("unknown language %q", language)
+	}
+	re, err := GlobRegexp(glob)
+	if err != nil {
+		return SyntaxMapping{}, err
+	}
+	return SyntaxMapping{
+		glob:     glob,
+		re:       re,
+		fullPath: strings.Contains(glob, "/"),
+		lexer:    lexer,
+	}, nil
+}
+
+// the last mapping that matches wins, so ones from the command line beat
+// the ones from the config file
+func mappedLexer(name string, mappings []SyntaxMapping) chroma.Lexer {
+	if len(mappings) == 0 {
+		return nil
+	}
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		abs = name
+	}
+	abs = filepath.ToSlash(abs)
+	base := filepath.Base(name)
+
+	for i := len(mappings) - 1; i >= 0; i-- {
+		m := mappings[i]
+		if m.fullPath {
+			if m.re.MatchString(abs) || m.re.MatchString(filepath.ToSlash(name)) {
+				return m.lexer
+			}
+		} else if m.re.MatchString(base) {
+			return m.lexer
+		}
+	}
+	return nil
+}
+
+// GlobRegexp compiles a shell glob. * and ? stay within a path element, **
+// crosses them, [...] is a class, {a,b} is either and \ takes the next
+// character as it is. {1..9} matches a number, any number: a regexp cant
+// check the bounds
+func GlobRegexp(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	depth := 0 // open {
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(glob[i])))
+			}
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+				if i+1 < len(glob) && glob[i+1] == '/' {
+					i++
+					b.WriteString("(?:.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			j := strings.IndexByte(glob[i:], ']')
+			if j < 0 {
+				return nil, fmt.Errorf("unterminated [ in %q", glob)
+			}
+			class := glob[i+1 : i+j]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += j
+		case '{':
+			j := strings.IndexByte(glob[i:], '}')
+			if j > 0 && numberRange.MatchString(glob[i:i+j+1]) {
+				b.WriteString(`[+-]?\d+`)
+				i += j
+				continue
+			}
+			// without a comma before the next } it's just a brace
+			if j < 0 || !strings.Contains(glob[i:i+j], ",") {
+				b.WriteString(`\{`)
+				continue
+			}
+			depth++
+			b.WriteString("(?:")
+		case '}':
+			if depth == 0 {
+				b.WriteString(`\}`)
+				continue
+			}
+			depth--
+			b.WriteString(")")
+		case ',':
+			if depth == 0 {
+				b.WriteString(",")
+				continue
+			}
+			b.WriteString("|")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	if depth > 0 {
+		return nil, fmt.Errorf("unterminated { in %q", glob)
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+var numberRange = regexp.MustCompile(`^\{[+-]?\d+\.\.[+-]?\d+\}$`)
+
+// extensions the inner name is found by dropping
+var compressedExts = []string{".gz", ".tgz", ".bz2", ".tbz2", ".zst", ".zlib", ".zz"}
+
+// InnerName is the name of what's in a compressed file: foo.json.gz is
+// highlighted as foo.json. tarballs keep being tarballs
+func InnerName(name string) string {
+	ext := filepath.Ext(name)
+	for _, e := range compressedExts {
+		if strings.EqualFold(ext, e) {
+			switch strings.ToLower(ext) {
+			case ".tgz", ".tbz2":
+				return strings.TrimSuffix(name, ext) + ".tar"
+			}
+			return strings.TrimSuffix(name, ext)
+		}
+	}
+	return name
+}
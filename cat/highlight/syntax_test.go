@@ -0,0 +1,78 @@
+package highlight
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGlobRegexp(t *testing.T) {
+	tests := []struct {
+		glob, path string
+		match      bool
+	}{
+		{"*.go", "main.go", true},
+		{"*.go", "dir/main.go", false},
+		{"**/*.go", "a/b/main.go", true},
+		{"**/*.go", "main.go", true},
+		{"?.c", "a.c", true},
+		{"[!a]x", "ax", false},
+		{"*.{go,c}", "main.c", true},
+		{"*.{go,c}", "main.h", false},
+		{"{src,lib}/**/*.js", "lib/x/y.js", true},
+		{"file{1..3}.txt", "file12.txt", true},
+		{"file{1..3}.txt", "filex.txt", false},
+		{`\*x`, "*x", true},
+		{`\*x`, "ax", false},
+		{"a{b}c", "a{b}c", true},
+		{"a,b", "a,b", true},
+	}
+	for _, tt := range tests {
+		re, err := GlobRegexp(tt.glob)
+		if err != nil {
+			t.Errorf("GlobRegexp(%q): %v", tt.glob, err)
+			continue
+		}
+		if got := re.MatchString(tt.path); got != tt.match {
+			t.Errorf("GlobRegexp(%q) matching %q = %v, want %v", tt.glob, tt.path, got, tt.match)
+		}
+	}
+
+	for _, glob := range []string{"[abc", "{a,{b,c}"} {
+		if _, err := GlobRegexp(glob); err == nil {
+			t.Errorf("GlobRegexp(%q) didnt fail", glob)
+		}
+	}
+}
+
+func TestHintedLexer(t *testing.T) {
+	filler := strings.Repeat("x\n", 20)
+	tests := []struct {
+		name   string
+		file   string
+		sample string
+		whole  bool
+		want   string // "" for no hint
+	}{
+		{"shebang", "x", "#!/bin/sh\necho hi\n", false, "Bash"},
+		{"env", "x", "#!/usr/bin/env python3\n", false, "Python"},
+		{"env -S", "x", "#!/usr/bin/env -S node --harmony\n", false, "JavaScript"},
+		{"emacs", "x", "# -*- mode: ruby -*-\n", false, "Ruby"},
+		{"emacs after shebang", "x", "#!/bin/sh\n# -*- mode: python -*-\n", false, "Python"},
+		{"vim first lines", "x", "x\n# vim: ft=python\n" + filler, false, "Python"},
+		{"vim beats shebang", "x", "#!/bin/sh\n# vim: set filetype=ruby :\n", false, "Ruby"},
+		{"vim last lines", "x", filler + "# vim: ft=python\n", true, "Python"},
+		{"vim last lines of a start", "x", filler + "# vim: ft=python\n", false, ""},
+		{"name beats shebang", "x.ts", "#!/usr/bin/env node\n", false, "TypeScript"},
+		{"vim beats name", "x.ts", "// vim: ft=javascript\n", false, "JavaScript"},
+		{"nothing", "x", "hello\n", true, ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if lexer := hintedLexer(tt.file, tt.sample, tt.whole); lexer != nil {
+			got = lexer.Config().Name
+		}
+		if got != tt.want {
+			t.Errorf("%s: hintedLexer = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package highlight
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// markers for ShowWhitespace
+const (
+	markSpace     = "·"
+	markTab       = "→"
+	markCR        = "␍"
+	markNBSP      = "⍽"
+	markZeroWidth = "∅"
+)
+
+// the lexer turns a lone \r into a line break and drops the one before \n,
+// so carriage returns are swapped for their marker before lexing
+func markCarriageReturns(line string) string {
+	return strings.ReplaceAll(line, "\r", markCR)
+}
+
+func whitespaceMarker(r rune) (string, bool) {
+	switch r {
+	case ' ':
+		return markSpace, true
+	case '\t':
+		return markTab, true
+	case '\r', '␍':
+		return markCR, true
+	case 0xa0, 0x2007, 0x202f: // no-break, figure and narrow no-break space
+		return markNBSP, true
+	case 0x200b, 0x200c, 0x200d, 0x2060, 0xfeff: // zero width ones
+		return markZeroWidth, true
+	}
+	return "", false
+}
+
+// swaps whitespace in a formatted line for visible markers, skipping the
+// escapes in it. tabs become an arrow padded out to the next tab stop, so
+// the line lines up like before and wraps like any other. markers are dim,
+// and the ones making up trailing whitespace get a red background. the
+// colours the formatter had on are put back after each one
+func showWhitespace(line string, tabWidth int, useColor bool, dim string) string {
+	// where the trailing whitespace starts, in bytes
+	trailing := len(line)
+	for i := 0; i < len(line); {
+		if n := escapeLen(line[i:]); n > 0 {
+			i += n
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(line[i:])
+		if _, ok := whitespaceMarker(r); !ok {
+			trailing = len(line)
+		} else if trailing == len(line) {
+			trailing = i
+		}
+		i += size
+	}
+
+	var b strings.Builder
+	var active strings.Builder // escapes since the last reset
+	col := 0
+	for i := 0; i < len(line); {
+		if n := escapeLen(line[i:]); n > 0 {
+			esc := line[i : i+n]
+			b.WriteString(esc)
+			if esc == "\x1b[0m" {
+				active.Reset()
+			} else {
+				active.WriteString(esc)
+			}
+			i += n
+			continue
+		}
+
+		// whole clusters go through as they are unless theres something to
+		// mark in them, like a zero width joiner between two letters. the
+		// joiners in a ZWJ emoji are part of the picture
+		size, w := nextCluster(line[i:], col, tabWidth)
+		if g := line[i : i+size]; !hasMarker(g) || w == 2 && strings.ContainsRune(g, 0x200d) {
+			b.WriteString(line[i : i+size])
+			col += w
+			i += size
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(line[i:])
+		mark, ok := whitespaceMarker(r)
+		if !ok {
+			_, w := nextCluster(line[i:i+size], col, tabWidth)
+			b.WriteString(line[i : i+size])
+			col += w
+			i += size
+			continue
+		}
+
+		width := 1
+		if r == '\t' {
+			_, width = nextCluster("\t", col, tabWidth)
+		}
+		switch {
+		case useColor && i >= trailing:
+			b.WriteString("\x1b[0m\x1b[41m" + mark + strings.Repeat(" ", width-1) + "\x1b[0m" + active.String())
+		case useColor:
+			b.WriteString("\x1b[0m" + dim + mark + "\x1b[0m" + strings.Repeat(" ", width-1) + active.String())
+		default:
+			b.WriteString(mark + strings.Repeat(" ", width-1))
+		}
+		col += width
+		i += size
+	}
+	return b.String()
+}
+
+// whether any rune in s would be swapped for a marker
+func hasMarker(s string) bool {
+	for _, r := range s {
+		if _, ok := whitespaceMarker(r); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// length of the CSI escape at the start of s, 0 if there isnt one
+func escapeLen(s string) int {
+	if len(s) < 2 || s[0] != '\x1b' || s[1] != '[' {
+		return 0
+	}
+	j := 2
+	for j < len(s) && (s[j] < 0x40 || s[j] > 0x7e) {
+		j++
+	}
+	if j < len(s) {
+		j++
+	}
+	return j
+}
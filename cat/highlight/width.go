@@ -0,0 +1,50 @@
+package highlight
+
+import (
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+)
+
+// how wide text is on a terminal goes by grapheme clusters, not runes: a
+// letter and its combining marks, a flag (two regional indicators), an
+// emoji with a skin tone or a variation selector, and a whole ZWJ sequence
+// like 👩‍💻 each take up one place. uniseg splits them by the Unicode rules
+// and sizes them from tables generated from EastAsianWidth.txt and the
+// emoji data, so CJK is 2 columns and ambiguous characters are 1
+
+// the length in bytes of the cluster s starts with and how many columns it
+// takes at col. tabs go to the next stop and control characters take none.
+// escapes arent text, callers step over them first
+func nextCluster(s string, col, tabWidth int) (size, width int) {
+	if s == "" {
+		return 0, 0
+	}
+	if s[0] == '\t' {
+		if tabWidth <= 0 {
+			tabWidth = 8
+		}
+		return 1, tabWidth - (col % tabWidth)
+	}
+	if s[0] < 0x20 || s[0] == 0x7f {
+		return 1, 0
+	}
+	cluster, _, width, _ := uniseg.FirstGraphemeClusterInString(s, -1)
+	if cluster == "" {
+		// not UTF-8, counted like the replacement character it shows as
+		_, size := utf8.DecodeRuneInString(s)
+		return size, 1
+	}
+	return len(cluster), width
+}
+
+// VisualWidth gives the columns s takes on a terminal, with tabs every 8
+func VisualWidth(s string) int {
+	w := 0
+	for i := 0; i < len(s); {
+		size, cw := nextCluster(s[i:], w, 8)
+		w += cw
+		i += size
+	}
+	return w
+}
@@ -0,0 +1,79 @@
+package highlight
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNextCluster(t *testing.T) {
+	tests := []struct {
+		name  string
+		s     string
+		col   int
+		size  int
+		width int
+	}{
+		{"ascii", "ab", 0, 1, 1},
+		{"combining mark", "e\u0301x", 0, 3, 1},
+		{"flag", "🇯🇵x", 0, 8, 2},
+		{"zwj sequence", "👩‍💻x", 0, 11, 2},
+		{"variation selector 16", "\u2764\ufe0fx", 0, 6, 2},
+		{"cjk", "漢字", 0, 3, 2},
+		{"tab at 0", "\tx", 0, 1, 8},
+		{"tab at 3", "\tx", 3, 1, 5},
+		{"control", "\x01x", 0, 1, 0},
+		{"invalid utf-8", "\xffx", 0, 1, 1},
+		{"empty", "", 0, 0, 0},
+	}
+	for _, tt := range tests {
+		size, width := nextCluster(tt.s, tt.col, 8)
+		if size != tt.size || width != tt.width {
+			t.Errorf("%s: nextCluster(%q, %d) = %d, %d, want %d, %d", tt.name, tt.s, tt.col, size, width, tt.size, tt.width)
+		}
+	}
+}
+
+func TestVisualWidth(t *testing.T) {
+	tests := []struct {
+		s     string
+		width int
+	}{
+		{"hello", 5},
+		{"cafe\u0301", 4},
+		{"🇯🇵🇫🇷", 4},
+		{"👩‍💻 code", 7},
+		{"\u2764\ufe0f!", 3},
+		{"日本語", 6},
+		{"a\tb", 9},
+		{"\x1b[31m", 4}, // escapes are text to VisualWidth, strip them first
+	}
+	for _, tt := range tests {
+		if got := VisualWidth(tt.s); got != tt.width {
+			t.Errorf("VisualWidth(%q) = %d, want %d", tt.s, got, tt.width)
+		}
+	}
+}
+
+func TestWrapLine(t *testing.T) {
+	tests := []struct {
+		name  string
+		line  string
+		width int
+		want  []string
+	}{
+		{"fits", "abc", 3, []string{"abc"}},
+		{"ascii", "abcdef", 4, []string{"abcd", "ef"}},
+		{"combining marks stay with their letter", "cafe\u0301s", 4, []string{"cafe\u0301", "s"}},
+		{"flags arent split", "a🇯🇵🇯🇵", 4, []string{"a🇯🇵", "🇯🇵"}},
+		{"zwj sequences arent split", "ab👩‍💻c", 3, []string{"ab", "👩‍💻c"}},
+		{"variation selectors go with their emoji", "a\u2764\ufe0fb", 2, []string{"a", "\u2764\ufe0f", "b"}},
+		{"cjk", "日本語です", 5, []string{"日本", "語で", "す"}},
+		{"escapes take no room", "\x1b[31mabcd\x1b[0m", 2, []string{"\x1b[31mab", "cd\x1b[0m"}},
+	}
+	for _, tt := range tests {
+		got := WrapLine(tt.line, tt.width, 8)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: WrapLine(%q, %d) = %q, want %q", tt.name, tt.line, tt.width, got, tt.want)
+		}
+	}
+}
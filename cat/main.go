@@ -4,68 +4,160 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
 	"strings"
-	"unicode/utf8"
+	"sync/atomic"
+
+	"gcat/highlight"
 
-	"github.com/alecthomas/chroma/v2"
-	"github.com/alecthomas/chroma/v2/formatters"
 	"github.com/alecthomas/chroma/v2/lexers"
 	"github.com/alecthomas/chroma/v2/styles"
 	flag "github.com/spf13/pflag"
 	"golang.org/x/term"
+	"golang.org/x/text/encoding"
 )
 
 var (
 	// cat
-	flagNumber         bool // -n --number
-	flagNumberNonblank bool // -b --number-nonblank
-	flagShowEnds       bool // -E --show-ends
-	flagShowTabs       bool // -T --show-tabs
-	flagSqueezeBlank   bool // -s --squeeze-blank
+	flagNumber          bool // -n --number
+	flagNumberNonblank  bool // -b --number-nonblank
+	flagShowEnds        bool // -E --show-ends
+	flagShowTabs        bool // -T --show-tabs
+	flagShowNonprinting bool // -v --show-nonprinting
+	flagSqueezeBlank    bool // -s --squeeze-blank
+	flagNumberPerFile   bool // --number-per-file
 
 	// bat
-	flagColorWhen string // --color=auto|never|always
-	flagTheme     string // --theme
-	flagLanguage  string // -l --language
-	flagWrapMode  string // --wrap=auto|never|character
-	flagWrapWidth int    // --wrap-width
-	flagTabs      int    // --tabs
-	flagTitles    bool   // --title
-	flagTitleNum  bool   // --title-number
-	flagOutput    string // -o --output
+	flagColorWhen    string   // --color=auto|never|always
+	flagTheme        string   // --theme
+	flagLanguage     string   // -l --language
+	flagWrapMode     string   // --wrap=auto|never|character|word
+	flagWrapMarker   bool     // --wrap-marker
+	flagWrapWidth    int      // --wrap-width
+	flagTabs         int      // --tabs
+	flagShowSpace    bool     // --show-whitespace
+	flagTitles       bool     // --title
+	flagTitleNum     bool     // --title-number
+	flagOutput       string   // -o --output
+	flagPaging       string   // --paging=auto|never|always
+	flagPager        string   // --pager
+	flagDiff         bool     // --diff
+	flagDiffOnly     bool     // --diff-only
+	flagDiffCtx      int      // --diff-context
+	flagRanges       []string // --line-range
+	flagHighlight    []string // -H --highlight-line
+	flagBinary       string   // --binary=auto|hex|raw|skip
+	flagMapSyntax    []string // -m --map-syntax
+	flagStyle        string   // --style
+	flagFormat       string   // --format
+	flagTrueColor    bool     // --true-color
+	flagColors       string   // --colors
+	flagDecompress   bool     // -z --decompress
+	flagNoDecompress bool     // --no-decompress
+	flagEncoding     string   // --encoding
+	flagRecursive    bool     // -r --recursive
+	flagInclude      []string // --include
+	flagExclude      []string // --exclude
+	flagMaxSize      string   // --max-size
+	flagFollow       bool     // -f --follow
+	flagPretty       bool     // --pretty
+	flagIndent       int      // --indent
+	flagSortKeys     bool     // --sort-keys
+	flagCompact      bool     // --compact
+	flagRender       bool     // --render
+	flagJobs         int      // -j --jobs
 
 	// conclusions
-	flagColor bool
-	flagWrap  bool
+	flagColor       bool
+	flagWrap        bool
+	wrapWords       bool
+	lineRanges      []highlight.LineRange
+	highlightRanges []highlight.LineRange
+	binaryMode      highlight.BinaryMode
+	syntaxMappings  []highlight.SyntaxMapping
+	colorDepth      int
+	includeGlobs    []pathGlob
+	excludeGlobs    []pathGlob
+	maxSize         int64
+	// --encoding, nil for auto
+	inputEncoding     encoding.Encoding
+	inputEncodingName string
+	// everything above, for highlight.Render
+	renderOpts highlight.Options
 )
 
 func main() {
 	args()
+	os.Exit(run())
+}
+
+// everything main does once the flags are in, returning the exit status so
+// the pager and the output file are closed before exiting
+func run() int {
+	files := flag.Args()
+	if len(files) == 0 {
+		stat, err := os.Stdin.Stat()
+		if err != nil || (stat.Mode()&os.ModeCharDevice) != 0 {
+			fmt.Fprintln(os.Stderr, "no files given")
+			return 1
+		}
+		files = []string{"-"}
+	}
+	files = expandArgs(files)
+	// one input makes one document, two standalone ones back to back dont
+	if renderOpts.Document != highlight.DocumentNone && len(files) > 1 {
+		fmt.Fprintf(os.Stderr, "--format=%s makes a document of one file, got %d\n", flagFormat, len(files))
+		return 1
+	}
 
-	out := os.Stdout
+	var out io.Writer = os.Stdout
 	if flagOutput != "" {
 		f, err := os.Create(flagOutput)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "cant open output file %s: %v\n", flagOutput, err)
-			os.Exit(1)
+			return 1
 		}
 		defer f.Close()
 		out = f
 	}
 
-	if len(flag.Args()) == 0 {
-		stat, err := os.Stdin.Stat()
-		if err == nil && (stat.Mode()&os.ModeCharDevice) == 0 {
-			catReader("<stdin>", os.Stdin, 0, out)
-			return
-		}
-		fmt.Fprintln(os.Stderr, "no files given")
-		os.Exit(1)
+	var errs io.Writer = os.Stderr
+	if pg := newPager(); pg != nil {
+		defer pg.Close()
+		out, errs = pg, pg.errWriter()
+	}
+
+	catFiles(files, out, errs)
+	return exitStatus()
+}
+
+var (
+	// set once any operand couldnt be printed
+	failed atomic.Bool
+	// lines -n has numbered in the files before this one
+	linesNumbered int
+)
+
+// 1 once an operand has failed, like cat(1). the built-in pager exits with
+// it when it's quit, since it doesnt hand control back to run
+func exitStatus() int {
+	if failed.Load() {
+		return 1
 	}
+	return 0
+}
 
-	for n, f := range flag.Args() {
-		catFile(f, n, out)
+// says what went wrong with a file on errs, stderr or where a worker keeps
+// it until the files output is printed. cat goes on with the rest but exits
+// with 1 at the end, like cat(1)
+func operandError(errs io.Writer, format string, a ...any) {
+	fmt.Fprintf(errs, format, a...)
+	// a file rendered ahead of its turn only fails once it's printed
+	if r, ok := errs.(renderedErrs); ok {
+		r.failed = true
+		return
 	}
+	failed.Store(true)
 }
 
 func args() {
@@ -74,15 +166,17 @@ func args() {
 	flag.BoolVarP(&flagNumberNonblank, "number-nonblank", "b", false, "number nonempty output lines, overrides -n")
 	flag.BoolVarP(&flagShowEnds, "show-ends", "E", false, "display $ at end of each line")
 	flag.BoolVarP(&flagShowTabs, "show-tabs", "T", false, "display TAB characters as ^I")
+	flag.BoolVarP(&flagShowNonprinting, "show-nonprinting", "v", false, "use ^ and M- notation, except for LFD and TAB")
 	flag.BoolVarP(&flagSqueezeBlank, "squeeze-blank", "s", false, "suppress repeated empty output lines")
+	flag.BoolVar(&flagNumberPerFile, "number-per-file", false, "start numbering from 1 in each file instead of carrying on")
 
 	// cat combos
 	var showAll bool
-	flag.BoolVarP(&showAll, "show-all", "A", false, "equivalent to -TE")
+	flag.BoolVarP(&showAll, "show-all", "A", false, "equivalent to -vET")
 	var eFlag bool
-	flag.BoolVarP(&eFlag, "show-nonprinting-ends", "e", false, "equivalent to -E")
+	flag.BoolVarP(&eFlag, "show-nonprinting-ends", "e", false, "equivalent to -vE")
 	var tFlag bool
-	flag.BoolVarP(&tFlag, "show-nonprinting-tabs", "t", false, "equivalent to -T")
+	flag.BoolVarP(&tFlag, "show-nonprinting-tabs", "t", false, "equivalent to -vT")
 	var uFlag bool
 	flag.BoolVarP(&uFlag, "unbuffered", "u", false, "ignored (POSIX compatibility)")
 
@@ -90,31 +184,93 @@ func args() {
 	flag.StringVar(&flagColorWhen, "color", "auto", "when to use colors: auto, never, always")
 	flag.StringVarP(&flagTheme, "theme", "S", "monokai", "set the syntax highlighting theme")
 	flag.StringVarP(&flagLanguage, "language", "l", "", "explicitly set the language for syntax highlighting")
-	flag.StringVar(&flagWrapMode, "wrap", "auto", "text-wrapping mode: auto, never, character")
+	flag.StringArrayVarP(&flagMapSyntax, "map-syntax", "m", nil, "use a language for paths matching a glob, e.g. '/etc/nginx/**/*.conf:nginx' or 'Jenkinsfile*:groovy'; repeatable")
+	flag.StringVar(&flagWrapMode, "wrap", "auto", "text-wrapping mode: auto, never, character, word (at spaces, keeping the indentation)")
+	flag.BoolVar(&flagWrapMarker, "wrap-marker", false, "mark wrapped lines with ↪ in the gutter")
 	flag.IntVar(&flagWrapWidth, "wrap-width", 0, "wrap width (default: terminal width); implies --wrap=character")
-	flag.IntVar(&flagTabs, "tabs", 8, "set the tab width")
+	flag.IntVar(&flagTabs, "tabs", 8, "set the tab width; without it tab_width or indent_size from .editorconfig is used")
+	flag.BoolVar(&flagShowSpace, "show-whitespace", false, "show spaces as ·, tabs as →, CR as ␍, no-break and zero-width spaces as ⍽ and ∅; trailing whitespace is red")
 	flag.BoolVar(&flagTitles, "title", false, "print a title header for each file")
 	flag.BoolVar(&flagTitleNum, "title-number", false, "include file number in title (implies --title)")
+	flag.StringVar(&flagStyle, "style", "", "comma-separated decorations: numbers, changes, grid, header, header-filesize, rule, full, plain")
 	flag.StringVarP(&flagOutput, "output", "o", "", "write output to file instead of stdout")
+	flag.StringVar(&flagFormat, "format", "", "output format: html, svg, rtf, ansi256, ansi16, plain (default: terminal colours when they're on)")
+	flag.BoolVar(&flagTrueColor, "true-color", false, "use 24-bit colour whatever the terminal says (same as --colors=16m)")
+	flag.StringVar(&flagColors, "colors", "", "colours the terminal supports: 8, 16, 256 or 16m (default: from $COLORTERM, $TERM and terminfo)")
+	flag.StringVar(&flagPaging, "paging", "auto", "when to page output: auto (if it doesn't fit the terminal), never, always")
+	flag.StringVar(&flagPager, "pager", "builtin", "pager to use: builtin, env (use $PAGER) or a command like 'less -R'")
+
+	flag.BoolVarP(&flagDecompress, "decompress", "z", false, "decompress gzip, bzip2, zlib and zstd input whatever it's called, stdin included")
+	flag.BoolVar(&flagNoDecompress, "no-decompress", false, "print compressed files as they are")
+
+	flag.StringVar(&flagEncoding, "encoding", "auto", "input encoding, like utf-16le, latin1 or cp1252; auto goes by the byte order mark and falls back to windows-1252 for text that isn't UTF-8")
+
+	flag.BoolVarP(&flagRecursive, "recursive", "r", false, "print every file under directories given, skipping what .gitignore does, each with a title")
+	flag.StringArrayVar(&flagInclude, "include", nil, "with -r, only print files matching a glob like '*.go'; repeatable")
+	flag.StringArrayVar(&flagExclude, "exclude", nil, "with -r, skip files and directories matching a glob like 'vendor' or '*_test.go'; repeatable")
+	flag.StringVar(&flagMaxSize, "max-size", "1M", "with -r, skip files bigger than this (0 for no limit)")
+
+	flag.BoolVarP(&flagFollow, "follow", "f", false, "keep printing what gets appended to the file, like tail -F; truncation and rotation are followed")
+
+	flag.BoolVar(&flagPretty, "pretty", false, "reformat JSON, XML, YAML and TOML before highlighting (TOML loses its comments)")
+	flag.IntVar(&flagIndent, "indent", 2, "indent width for --pretty")
+	flag.BoolVar(&flagSortKeys, "sort-keys", false, "with --pretty, sort object keys (and XML attributes)")
+	flag.BoolVar(&flagCompact, "compact", false, "with --pretty, write documents as compactly as the format allows")
+
+	flag.BoolVar(&flagRender, "render", false, "render Markdown files for the terminal instead of highlighting their source")
+
+	flag.IntVarP(&flagJobs, "jobs", "j", 0, "highlight this many files at once (default: one per CPU); output stays in order")
 
 	var plain bool
 	flag.BoolVarP(&plain, "plain", "p", false, "disable decorations: no titles, no line numbers (color still applies)")
 
+	flag.BoolVar(&flagDiff, "diff", false, "mark lines added (+), modified (~) and removed (-) since the last commit")
+	flag.BoolVar(&flagDiffOnly, "diff-only", false, "only print changed lines and their context (implies --diff)")
+	flag.IntVar(&flagDiffCtx, "diff-context", 2, "lines of context around changes for --diff-only")
+	flag.StringArrayVar(&flagRanges, "line-range", nil, "only print lines in range, like slice: 10:40, 10l:40l, :40, 40:, -20: (last 20), 7 (lines 1-7, as slice's file[7l]), 7:7 (just line 7); repeatable")
+	flag.StringArrayVarP(&flagHighlight, "highlight-line", "H", nil, "highlight lines in range, same syntax as --line-range; repeatable")
+
+	flag.StringVar(&flagBinary, "binary", "auto", "what to do with binary input: hex (dump), raw (print as-is), skip (print a notice); auto is skip with -r, hex on a terminal, raw otherwise")
+
 	var listThemes bool
 	flag.BoolVar(&listThemes, "list-themes", false, "display list of supported themes")
 	var listLanguages bool
 	flag.BoolVar(&listLanguages, "list-languages", false, "display list of supported languages")
 
-	flag.Parse()
+	var noConfig bool
+	flag.BoolVar(&noConfig, "no-config", false, "don't read default flags from the config file")
+	var showConfig bool
+	flag.BoolVar(&showConfig, "config-file", false, "print the path of the config file and exit")
+
+	if err := flag.CommandLine.Parse(withConfigArgs(os.Args[1:])); err != nil {
+		os.Exit(2)
+	}
+
+	if showConfig {
+		switch {
+		case configLoaded != "":
+			fmt.Println(configLoaded)
+		case noConfig:
+			fmt.Println("no config loaded: --no-config was given")
+		case configPath() == "":
+			fmt.Println("no config loaded: no home directory to look in")
+		default:
+			fmt.Printf("no config loaded: looked for %s\n", configPath())
+		}
+		os.Exit(0)
+	}
 
 	if showAll {
+		flagShowNonprinting = true
 		flagShowEnds = true
 		flagShowTabs = true
 	}
 	if eFlag {
+		flagShowNonprinting = true
 		flagShowEnds = true
 	}
 	if tFlag {
+		flagShowNonprinting = true
 		flagShowTabs = true
 	}
 
@@ -129,10 +285,14 @@ func args() {
 
 	if flagWrapWidth > 0 {
 		flagWrap = true
+		wrapWords = flagWrapMode == "word"
 	} else {
 		switch flagWrapMode {
 		case "character":
 			flagWrap = true
+		case "word":
+			flagWrap = true
+			wrapWords = true
 		case "never":
 			flagWrap = false
 		default: // "auto"
@@ -140,280 +300,347 @@ func args() {
 		}
 	}
 
+	if !flagWrap {
+		flagWrapMarker = false
+	}
+
+	parseStyle(flagStyle)
+
 	if flagNumberNonblank {
 		flagNumber = false
 	}
 	if flagTitleNum {
 		flagTitles = true
 	}
+	if flagDiffOnly {
+		flagDiff = true
+	}
+	switch flagBinary {
+	case "hex":
+		binaryMode = highlight.BinaryHex
+	case "raw":
+		binaryMode = highlight.BinaryRaw
+	case "skip":
+		binaryMode = highlight.BinarySkip
+	case "auto":
+		binaryMode = highlight.BinaryRaw
+		if flagRecursive {
+			binaryMode = highlight.BinarySkip
+		} else if flagOutput == "" && term.IsTerminal(int(os.Stdout.Fd())) {
+			binaryMode = highlight.BinaryHex
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "invalid --binary %q: want auto, hex, raw or skip\n", flagBinary)
+		os.Exit(1)
+	}
+
+	switch flagPaging {
+	case "auto", "never", "always":
+	default:
+		fmt.Fprintf(os.Stderr, "invalid --paging %q: want auto, never or always\n", flagPaging)
+		os.Exit(1)
+	}
+	switch flagPager {
+	case "builtin", "env":
+	default:
+		words := strings.Fields(flagPager)
+		if len(words) == 0 {
+			fmt.Fprintln(os.Stderr, "invalid --pager \"\": want builtin, env or a command")
+			os.Exit(1)
+		}
+		if _, err := exec.LookPath(words[0]); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid --pager %q: %v\n", flagPager, err)
+			os.Exit(1)
+		}
+	}
+
+	switch flagFormat {
+	case "", "html", "svg", "rtf", "ansi256", "ansi16", "plain":
+	default:
+		fmt.Fprintf(os.Stderr, "invalid --format %q: want html, svg, rtf, ansi256, ansi16 or plain\n", flagFormat)
+		os.Exit(1)
+	}
+
+	switch {
+	case flagTrueColor:
+		colorDepth = highlight.TrueColor
+	case flagColors != "":
+		depth, err := highlight.ParseColorDepth(flagColors)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid --colors %q: %v\n", flagColors, err)
+			os.Exit(1)
+		}
+		colorDepth = depth
+	case flagFormat == "ansi256":
+		colorDepth = 256
+	case flagFormat == "ansi16":
+		colorDepth = 16
+	default:
+		colorDepth = highlight.DetectColorDepth()
+	}
+
+	if flagEncoding != "auto" {
+		enc, name, err := highlight.LookupEncoding(flagEncoding)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid --encoding %q: %v\n", flagEncoding, err)
+			os.Exit(1)
+		}
+		inputEncoding, inputEncodingName = enc, name
+	}
+
+	includeGlobs = parsePathGlobs("include", flagInclude)
+	excludeGlobs = parsePathGlobs("exclude", flagExclude)
+	size, err := parseSize(flagMaxSize)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid --max-size %q: %v\n", flagMaxSize, err)
+		os.Exit(1)
+	}
+	maxSize = size
+	if flagFollow && len(flag.Args()) > 1 {
+		fmt.Fprintln(os.Stderr, "-f follows one file")
+		os.Exit(1)
+	}
+	if flagFollow && flagPretty {
+		fmt.Fprintln(os.Stderr, "--pretty needs the whole document, it cant be used with -f")
+		os.Exit(1)
+	}
+	// a dump of many files needs to say which is which
+	if flagRecursive && !styleHeader {
+		flagTitles = true
+	}
+
+	lineRanges = parseLineRanges("line-range", flagRanges)
+	highlightRanges = parseLineRanges("highlight-line", flagHighlight)
 	if plain {
 		flagTitles = false
 		flagTitleNum = false
 		flagNumber = false
 		flagNumberNonblank = false
+		parseStyle("plain")
 	}
 
+	loadUserDefinitions()
+	syntaxMappings = parseSyntaxMappings(flagMapSyntax)
+
 	if listThemes {
 		for _, s := range styles.Names() {
-			fmt.Printf("- %s\n", s)
+			if userThemes[s] {
+				fmt.Printf("- %s (user)\n", s)
+			} else {
+				fmt.Printf("- %s\n", s)
+			}
 		}
 		os.Exit(0)
 	}
 	if listLanguages {
 		for _, lx := range lexers.GlobalLexerRegistry.Lexers {
 			cfg := lx.Config()
+			name := cfg.Name
+			if userLanguages[name] {
+				name += " (user)"
+			}
 			fmt.Printf("- %-20s aliases=%-30s files=%s\n",
-				cfg.Name, strings.Join(cfg.Aliases, ","), strings.Join(cfg.Filenames, ","))
+				name, strings.Join(cfg.Aliases, ","), strings.Join(cfg.Filenames, ","))
 		}
 		os.Exit(0)
 	}
-}
 
-func catFile(fpath string, n int, out io.Writer) {
-	f, err := os.Open(fpath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "cant open file %s: %v\n", fpath, err)
-		return
-	}
-	defer f.Close()
-	catReader(fpath, f, n, out)
+	renderOpts = renderOptions()
 }
 
-func catReader(name string, r io.Reader, n int, out io.Writer) {
-	if flagTitles && flagTitleNum {
-		fmt.Fprintf(out, "== [#%d: %s] ==\n", n+1, name)
-	} else if flagTitles {
-		fmt.Fprintf(out, "== [%s] ==\n", name)
-	}
-
-	raw, err := io.ReadAll(r)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "cant read %s: %v\n", name, err)
-		return
-	}
-
-	var lexer chroma.Lexer
-	if flagLanguage != "" {
-		lexer = lexers.Get(flagLanguage)
-		if lexer == nil {
-			fmt.Fprintf(os.Stderr, "unknown language %q, falling back to autodetect\n", flagLanguage)
-		}
-	}
-	if lexer == nil {
-		lexer = lexers.Match(name)
-	}
-	if lexer == nil {
-		lexer = lexers.Analyse(string(raw))
-	}
-	if lexer == nil {
-		lexer = lexers.Fallback
-	}
-
+// the conclusions as the highlight package takes them
+func renderOptions() highlight.Options {
 	style := styles.Get(flagTheme)
 	if style == nil {
 		fmt.Fprintf(os.Stderr, "unknown theme %q\n", flagTheme)
 		os.Exit(1)
 	}
 
+	// asking for a format means wanting it, -o or not
 	useColor := flagColor && flagOutput == ""
-	var formatter chroma.Formatter
-	if useColor {
-		formatter = formatters.TTY16m
-	} else {
-		formatter = formatters.NoOp
-	}
-
-	iterator, err := lexer.Tokenise(nil, string(raw))
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "highlight error: %v\n", err)
-		return
-	}
-
-	var buf strings.Builder
-	if err = formatter.Format(&buf, style, iterator); err != nil {
-		fmt.Fprintf(os.Stderr, "format error: %v\n", err)
-		return
+	switch flagFormat {
+	case "ansi256", "ansi16":
+		useColor = true
+	case "plain":
+		useColor = false
 	}
 
-	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
-	totalLines := len(lines)
-
-	wrapWidth := flagWrapWidth
-	if wrapWidth <= 0 {
-		wrapWidth = termWidth()
+	wrap := highlight.WrapNever
+	switch {
+	case flagWrap && wrapWords:
+		wrap = highlight.WrapWord
+	case flagWrap:
+		wrap = highlight.WrapCharacter
 	}
+	width := flagWrapWidth
+	if width <= 0 {
+		width = termWidth()
+	}
+
+	opts := highlight.Options{
+		Style:    style,
+		Color:    useColor,
+		Depth:    colorDepth,
+		Language: flagLanguage,
+		Mappings: syntaxMappings,
+
+		Number:          flagNumber,
+		NumberNonblank:  flagNumberNonblank,
+		ShowEnds:        flagShowEnds,
+		ShowTabs:        flagShowTabs,
+		ShowNonprinting: flagShowNonprinting,
+		SqueezeBlank:    flagSqueezeBlank,
+		ShowWhitespace:  flagShowSpace,
+		TabWidth:        flagTabs,
+
+		Wrap:       wrap,
+		Width:      width,
+		WrapMarker: flagWrapMarker,
+
+		Title:       flagTitles,
+		TitleNumber: flagTitleNum,
+		Header:      styleHeader,
+		FileSize:    styleFileSize,
+		Grid:        styleGrid,
+		Rule:        styleRule,
+		Diff:        flagDiff,
+		DiffOnly:    flagDiffOnly,
+		DiffContext: flagDiffCtx,
+
+		LineRanges:      lineRanges,
+		HighlightRanges: highlightRanges,
+		Binary:          binaryMode,
+
+		Encoding:     inputEncoding,
+		EncodingName: inputEncodingName,
+		Pretty:       flagPretty,
+		Indent:       flagIndent,
+		SortKeys:     flagSortKeys,
+		Compact:      flagCompact,
+		Markdown:     flagRender,
+	}
+	switch flagFormat {
+	case "html":
+		opts.Document = highlight.DocumentHTML
+	case "svg":
+		opts.Document = highlight.DocumentSVG
+	case "rtf":
+		opts.Document = highlight.DocumentRTF
+	}
+	return opts
+}
 
-	numWidth := 0
-	if flagNumber || flagNumberNonblank {
-		numWidth = len(fmt.Sprintf("%d", totalLines)) + 1
-	}
-
-	lineNum := 0
-	prevBlank := false
-	for _, line := range lines {
-		isBlank := strings.TrimSpace(stripANSI(line)) == ""
-
-		if flagSqueezeBlank && isBlank && prevBlank {
-			continue
-		}
-		prevBlank = isBlank
-
-		printNum := flagNumber || (flagNumberNonblank && !isBlank)
-		if printNum {
-			lineNum++
-		}
-
-		displayLine := line
-		if flagShowTabs {
-			displayLine = strings.ReplaceAll(displayLine, "\t", "^I")
-		}
-		if flagShowEnds {
-			displayLine = appendBeforeTrailingReset(displayLine, "$")
-		}
-
-		var outputLines []string
-		if flagWrap && numWidth < wrapWidth {
-			outputLines = wrapLineVisual(displayLine, wrapWidth-numWidth, flagTabs)
-		} else {
-			outputLines = []string{displayLine}
+func parseLineRanges(flagName string, args []string) []highlight.LineRange {
+	var ranges []highlight.LineRange
+	for _, a := range args {
+		r, err := highlight.ParseLineRange(a)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid --%s %q: %v\n", flagName, a, err)
+			os.Exit(1)
 		}
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
 
-		indent := strings.Repeat(" ", numWidth)
-
-		for j, l := range outputLines {
-			switch {
-			case printNum && j == 0:
-				if useColor {
-					fmt.Fprintf(out, "\x1b[2;37m%*d\x1b[0m %s\n", numWidth-1, lineNum, l)
-				} else {
-					fmt.Fprintf(out, "%*d %s\n", numWidth-1, lineNum, l)
-				}
-			case (flagNumber || flagNumberNonblank) && j == 0:
-				fmt.Fprintf(out, "%s%s\n", indent, l)
-			default:
-				if numWidth > 0 && j > 0 {
-					fmt.Fprintf(out, "%s%s\n", indent, l)
-				} else {
-					fmt.Fprintln(out, l)
-				}
-			}
+func parseSyntaxMappings(args []string) []highlight.SyntaxMapping {
+	var mappings []highlight.SyntaxMapping
+	for _, a := range args {
+		m, err := highlight.ParseSyntaxMapping(a)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid --map-syntax %q: %v\n", a, err)
+			os.Exit(1)
 		}
+		mappings = append(mappings, m)
 	}
+	return mappings
 }
 
-// wraps it and leaves ANSI alone and also handles it right
-func wrapLineVisual(line string, width, tabWidth int) []string {
-	if width <= 0 {
-		return []string{line}
+// errs is where messages about the file go, see operandError
+func catFile(fpath string, n int, out, errs io.Writer) {
+	if fpath == "-" {
+		catStdin(n, out, errs)
+		return
 	}
 
-	var result []string
-	var cur strings.Builder
-	visCol := 0
-
-	i := 0
-	for i < len(line) {
-		if line[i] == '\x1b' && i+1 < len(line) && line[i+1] == '[' {
-			j := i + 2
-			for j < len(line) && (line[j] < 0x40 || line[j] > 0x7e) {
-				j++
-			}
-			if j < len(line) {
-				j++
-			}
-			cur.WriteString(line[i:j])
-			i = j
-			continue
-		}
+	f, err := os.Open(fpath)
+	if err != nil {
+		operandError(errs, "cant open file %s: %v\n", fpath, err)
+		return
+	}
+	defer f.Close()
+	if st, err := f.Stat(); err == nil && st.IsDir() {
+		operandError(errs, "cant cat %s: is a directory (-r prints what's in it)\n", fpath)
+		return
+	}
 
-		r, size := utf8.DecodeRuneInString(line[i:])
-		rw := runeVisualWidth(r, visCol, tabWidth)
+	if flagFollow {
+		fl := newFollower(fpath, f)
+		defer fl.Close()
+		catReader(fpath, fl, n, out, errs, nil)
+		return
+	}
 
-		if visCol+rw > width && visCol > 0 {
-			result = append(result, cur.String())
-			cur.Reset()
-			visCol = 0
+	var r io.Reader = f
+	if !flagNoDecompress {
+		var done func()
+		if r, done, err = decompress(fpath, f); err != nil {
+			operandError(errs, "cant decompress %s: %v\n", fpath, err)
+			return
 		}
-
-		cur.WriteString(line[i : i+size])
-		visCol += rw
-		i += size
+		defer done()
 	}
 
-	if cur.Len() > 0 {
-		result = append(result, cur.String())
-	}
-	if len(result) == 0 {
-		result = []string{""}
+	// git markers are for the file as it is on disk
+	var changes highlight.Changes
+	if flagDiff && r == io.Reader(f) {
+		if changes, err = highlight.GitChanges(fpath); err != nil {
+			fmt.Fprintln(errs, err)
+		}
 	}
-	return result
+	catReader(fpath, r, n, out, errs, changes)
 }
 
-func runeVisualWidth(r rune, col, tabWidth int) int {
-	if r == '\t' {
-		if tabWidth <= 0 {
-			tabWidth = 8
-		}
-		return tabWidth - (col % tabWidth)
-	}
-	if r < 0x20 || r == 0x7f {
-		return 0
-	}
-	if r >= 0x1100 {
-		if r <= 0x115f || r == 0x2329 || r == 0x232a ||
-			(r >= 0x2e80 && r <= 0xa4cf && r != 0x303f) ||
-			(r >= 0xac00 && r <= 0xd7a3) ||
-			(r >= 0xf900 && r <= 0xfaff) ||
-			(r >= 0xfe10 && r <= 0xfe19) ||
-			(r >= 0xfe30 && r <= 0xfe6f) ||
-			(r >= 0xff00 && r <= 0xff60) ||
-			(r >= 0xffe0 && r <= 0xffe6) ||
-			(r >= 0x1b000 && r <= 0x1b001) ||
-			(r >= 0x1f300 && r <= 0x1f64f) ||
-			(r >= 0x1f900 && r <= 0x1f9ff) ||
-			(r >= 0x20000 && r <= 0x2fffd) ||
-			(r >= 0x30000 && r <= 0x3fffd) {
-			return 2
+// - is stdin wherever it is in the operands. once it's been read to the
+// end another - just finds it empty, as with cat(1)
+func catStdin(n int, out, errs io.Writer) {
+	var in io.Reader = os.Stdin
+	if flagDecompress && !flagNoDecompress {
+		r, done, err := decompress("<stdin>", os.Stdin)
+		if err != nil {
+			operandError(errs, "cant decompress <stdin>: %v\n", err)
+			return
 		}
+		defer done()
+		in = r
 	}
-	return 1
+	catReader("<stdin>", in, n, out, errs, nil)
 }
 
-func stripANSI(s string) string {
-	var b strings.Builder
-	i := 0
-	for i < len(s) {
-		if s[i] == '\x1b' && i+1 < len(s) && s[i+1] == '[' {
-			j := i + 2
-			for j < len(s) && (s[j] < 0x40 || s[j] > 0x7e) {
-				j++
-			}
-			if j < len(s) {
-				j++
-			}
-			i = j
-			continue
+// changes are the git markers for the gutter, nil when there are none
+func catReader(name string, r io.Reader, n int, out, errs io.Writer, changes highlight.Changes) {
+	opts := renderOpts
+	opts.Warn = func(err error) { fmt.Fprintln(errs, err) }
+	opts.Index = n
+	opts.Changes = changes
+	// --tabs, from the command line or the config, beats .editorconfig
+	if name != "<stdin>" && !flag.CommandLine.Changed("tabs") {
+		if tabs := editorTabWidth(name); tabs > 0 {
+			opts.TabWidth = tabs
 		}
-		b.WriteByte(s[i])
-		i++
 	}
-	return b.String()
-}
-
-func appendBeforeTrailingReset(line, insert string) string {
-	if len(line) == 0 {
-		return insert
+	// numbering carries on from the file before, as cat(1) does
+	carry := (flagNumber || flagNumberNonblank) && !flagNumberPerFile
+	if carry {
+		opts.FirstNumber = linesNumbered
 	}
-	if line[len(line)-1] == 'm' {
-		j := len(line) - 2
-		for j >= 0 && line[j] != '\x1b' {
-			j--
-		}
-		if j >= 0 && j+1 < len(line) && line[j+1] == '[' {
-			return line[:j] + insert + line[j:]
-		}
+	last, err := highlight.Render(out, name, r, opts)
+	if err != nil {
+		operandError(errs, "cant print %s: %v\n", name, err)
+	}
+	if carry {
+		linesNumbered = last
 	}
-	return line + insert
 }
 
 func shouldUseColor() bool {
@@ -0,0 +1,562 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gcat/highlight"
+
+	"golang.org/x/term"
+)
+
+// pager sits between catReader and the terminal. it keeps the formatted
+// lines it gets and only takes over the screen once they dont fit (or right
+// away with --paging=always); short output is printed as-is on close
+type pager struct {
+	mu      sync.Mutex
+	lines   []string // screen rows: lines wrapped to the width they came in at
+	partial []byte
+	done    bool
+	started bool
+
+	width, height int
+	command       []string // external pager, nil for the built-in one
+
+	ext    io.WriteCloser // stdin of the external pager once running
+	cmd    *exec.Cmd
+	update chan struct{}
+
+	// the first line on screen. Write waits on room while the built-in pager
+	// is showing and more than pagerAhead screens are buffered past it, so a
+	// huge input isnt held in memory all at once
+	top  int
+	room *sync.Cond
+
+	// output is held back only while it isnt clear whether it fits. once it
+	// goes quiet for pagerIdle it's printed as it is and paging is off, so a
+	// slow pipe shows up as it comes like it would without a pager
+	idle *time.Timer
+	// messages for stderr while a pager has the screen, printed once it's
+	// gone so they dont end up under it. errsMu is taken inside mu, never
+	// the other way round
+	errsMu sync.Mutex
+	errs   bytes.Buffer
+}
+
+const (
+	// screens of lines kept ready below the view
+	pagerAhead = 2
+	pagerIdle  = 200 * time.Millisecond
+)
+
+// returns nil when output shouldnt be paged at all
+func newPager() *pager {
+	if flagPaging == "never" || flagOutput != "" || flagFollow {
+		return nil
+	}
+	if !term.IsTerminal(int(os.Stdout.Fd())) {
+		return nil
+	}
+	w, h, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || h <= 1 {
+		return nil
+	}
+
+	cmd := flagPager
+	if cmd == "env" {
+		cmd = os.Getenv("PAGER")
+	}
+	var command []string
+	if cmd != "" && cmd != "builtin" {
+		command = strings.Fields(cmd)
+		// less shows escapes as ^[ unless told otherwise
+		if filepath.Base(command[0]) == "less" && len(command) == 1 {
+			command = append(command, "-R")
+		}
+	}
+
+	p := &pager{
+		width:   w,
+		height:  h,
+		command: command,
+		update:  make(chan struct{}, 1),
+	}
+	p.room = sync.NewCond(&p.mu)
+	return p
+}
+
+func (p *pager) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	if p.ext != nil {
+		p.mu.Unlock()
+		p.writeExternal(b)
+		return len(b), nil
+	}
+
+	p.partial = append(p.partial, b...)
+	for {
+		i := bytes.IndexByte(p.partial, '\n')
+		if i < 0 {
+			break
+		}
+		p.addLine(string(p.partial[:i]))
+		p.partial = p.partial[i+1:]
+	}
+
+	start := !p.started && (flagPaging == "always" || len(p.lines) >= p.height)
+	if !p.started && !start && flagPaging != "always" {
+		if p.idle == nil {
+			p.idle = time.AfterFunc(pagerIdle, p.settle)
+		} else {
+			p.idle.Reset(pagerIdle)
+		}
+	}
+	p.mu.Unlock()
+
+	if start {
+		p.start()
+	}
+	p.poke()
+
+	p.mu.Lock()
+	for p.started && p.ext == nil && len(p.lines)-p.top > pagerAhead*p.height {
+		p.room.Wait()
+	}
+	p.mu.Unlock()
+	return len(b), nil
+}
+
+// must be called with mu held
+func (p *pager) addLine(line string) {
+	p.lines = append(p.lines, carryColors(highlight.WrapLine(line, p.width, flagTabs))...)
+}
+
+var sgrEscape = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+// pieces of a wrapped line, each after the first starting with the colours
+// that were on where the one before it ended, so it can be drawn on its own
+func carryColors(pieces []string) []string {
+	var active []string
+	for i, piece := range pieces {
+		if i > 0 && len(active) > 0 {
+			pieces[i] = strings.Join(active, "") + piece
+		}
+		for _, esc := range sgrEscape.FindAllString(piece, -1) {
+			if esc == "\x1b[0m" || esc == "\x1b[m" {
+				active = active[:0]
+			} else {
+				active = append(active, esc)
+			}
+		}
+	}
+	return pieces
+}
+
+// input went quiet before filling the screen: print what there is and
+// stop paging
+func (p *pager) settle() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.started || p.done {
+		return
+	}
+	p.flushHeld()
+	p.started = true
+	p.ext = nopWriteCloser{os.Stdout}
+}
+
+// prints the lines held back so far, must be called with mu held
+func (p *pager) flushHeld() {
+	var buf bytes.Buffer
+	for _, l := range p.lines {
+		buf.WriteString(l)
+		buf.WriteByte('\n')
+	}
+	buf.Write(p.partial)
+	os.Stdout.Write(buf.Bytes())
+	p.lines, p.partial = nil, nil
+}
+
+// where messages about files go while the pager is in front. before it has
+// the screen, what was held back is printed first so they come out in order
+// with the output. while it has the screen they wait until it's gone
+func (p *pager) errWriter() io.Writer {
+	return pagerErrs{p}
+}
+
+type pagerErrs struct{ p *pager }
+
+func (e pagerErrs) Write(b []byte) (int, error) {
+	p := e.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case !p.started:
+		p.flushHeld()
+	case p.ext == nil || p.cmd != nil:
+		p.errsMu.Lock()
+		defer p.errsMu.Unlock()
+		return p.errs.Write(b)
+	}
+	return os.Stderr.Write(b)
+}
+
+// must be called once the pager is gone
+func (p *pager) flushErrs() {
+	p.errsMu.Lock()
+	defer p.errsMu.Unlock()
+	os.Stderr.Write(p.errs.Bytes())
+	p.errs.Reset()
+}
+
+// Close prints everything if paging never kicked in, otherwise waits for
+// the user to quit the pager. the built-in one exits the process then, with
+// exitStatus since everything has been printed by now
+func (p *pager) Close() {
+	p.mu.Lock()
+	if len(p.partial) > 0 && p.ext == nil {
+		p.addLine(string(p.partial))
+		p.partial = nil
+	}
+	p.done = true
+	if p.idle != nil {
+		p.idle.Stop()
+	}
+	start := !p.started && flagPaging == "always"
+	p.mu.Unlock()
+
+	if start {
+		p.start()
+	}
+
+	p.mu.Lock()
+	started, ext := p.started, p.ext
+	p.mu.Unlock()
+
+	switch {
+	case !started:
+		p.mu.Lock()
+		p.flushHeld()
+		p.mu.Unlock()
+	case ext != nil:
+		ext.Close()
+		if p.cmd != nil {
+			p.cmd.Wait()
+		}
+		p.flushErrs()
+	default:
+		// the pager exits the process once the user quits
+		p.poke()
+		select {}
+	}
+}
+
+func (p *pager) poke() {
+	select {
+	case p.update <- struct{}{}:
+	default:
+	}
+}
+
+func (p *pager) start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.started {
+		return
+	}
+	p.started = true
+
+	if p.command != nil {
+		err := p.startExternal()
+		if err == nil {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "cant run pager %s: %v, using the built-in one\n", p.command[0], err)
+	}
+
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		// nowhere to read keys from, so behave like --paging=never
+		p.flushHeld()
+		p.ext = nopWriteCloser{os.Stdout}
+		return
+	}
+	go p.run(tty)
+}
+
+// must be called with mu held
+func (p *pager) startExternal() error {
+	cmd := exec.Command(p.command[0], p.command[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	p.cmd = cmd
+	p.ext = in
+
+	var buf bytes.Buffer
+	for _, l := range p.lines {
+		buf.WriteString(l)
+		buf.WriteByte('\n')
+	}
+	buf.Write(p.partial)
+	p.lines, p.partial = nil, nil
+	p.writeExternal(buf.Bytes())
+	return nil
+}
+
+func (p *pager) writeExternal(b []byte) {
+	if _, err := p.ext.Write(b); err != nil {
+		// the pager was quit, nobody is reading anymore
+		if p.cmd != nil {
+			p.cmd.Wait()
+		}
+		p.flushErrs()
+		os.Exit(exitStatus())
+	}
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// the built-in pager: runs until q, redrawing on keys and new lines
+func (p *pager) run(tty *os.File) {
+	state, err := term.MakeRaw(int(tty.Fd()))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant set up terminal: %v\n", err)
+		os.Exit(1)
+	}
+	// alternate screen, hidden cursor
+	fmt.Fprint(os.Stdout, "\x1b[?1049h\x1b[?25l")
+	quit := func(code int) {
+		fmt.Fprint(os.Stdout, "\x1b[?25h\x1b[?1049l")
+		term.Restore(int(tty.Fd()), state)
+		p.flushErrs()
+		os.Exit(code)
+	}
+
+	keys := readKeys(tty)
+	top := 0
+	var query, message string
+	var prompt *strings.Builder
+
+	for {
+		p.mu.Lock()
+		rows := p.bodyRows()
+		total := len(p.lines)
+		maxTop := max(0, total-rows)
+		top = min(max(top, 0), maxTop)
+		p.top = top
+		p.room.Broadcast()
+
+		status := message
+		switch {
+		case prompt != nil:
+			status = "/" + prompt.String()
+		case status != "":
+		case p.done && top >= maxTop:
+			status = "(END)"
+		default:
+			status = fmt.Sprintf("lines %d-%d/%d", top+1, min(top+rows, total), total)
+			if !p.done {
+				status += "+"
+			}
+		}
+		p.draw(top, rows, status)
+		p.mu.Unlock()
+		message = ""
+
+		var key string
+		select {
+		case k, ok := <-keys:
+			if !ok {
+				quit(exitStatus())
+			}
+			key = k
+		case <-p.update:
+			continue
+		}
+
+		if prompt != nil {
+			switch key {
+			case "enter":
+				query = prompt.String()
+				prompt = nil
+				top, message = p.search(query, top, 1)
+			case "esc", "ctrl-c":
+				prompt = nil
+			case "backspace":
+				s := []rune(prompt.String())
+				if len(s) == 0 {
+					prompt = nil
+					break
+				}
+				prompt.Reset()
+				prompt.WriteString(string(s[:len(s)-1]))
+			default:
+				if len([]rune(key)) == 1 {
+					prompt.WriteString(key)
+				}
+			}
+			continue
+		}
+
+		switch key {
+		case "q", "Q", "ctrl-c":
+			quit(exitStatus())
+		case "j", "e", "down", "enter", "ctrl-n":
+			top++
+		case "k", "y", "up", "ctrl-p":
+			top--
+		case " ", "f", "pgdn", "ctrl-f":
+			top += rows
+		case "b", "pgup", "ctrl-b":
+			top -= rows
+		case "d", "ctrl-d":
+			top += rows / 2
+		case "u", "ctrl-u":
+			top -= rows / 2
+		case "g", "<", "home":
+			top = 0
+		case "G", ">", "end":
+			top = maxTop
+		case "/":
+			prompt = &strings.Builder{}
+		case "n":
+			top, message = p.search(query, top, 1)
+		case "N":
+			top, message = p.search(query, top, -1)
+		}
+	}
+}
+
+// must be called with mu held
+func (p *pager) bodyRows() int {
+	// picks up terminal resizes between redraws
+	if w, h, err := term.GetSize(int(os.Stdout.Fd())); err == nil && w > 0 && h > 1 {
+		p.width, p.height = w, h
+	}
+	return p.height - 1
+}
+
+// must be called with mu held
+func (p *pager) draw(top, rows int, status string) {
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	// clearing before writing, a line filling the last column would
+	// otherwise lose its last character to the erase
+	for i := range rows {
+		b.WriteString("\x1b[K")
+		if n := top + i; n < len(p.lines) {
+			b.WriteString(highlight.WrapLine(p.lines[n], p.width, flagTabs)[0])
+		} else {
+			b.WriteString("~")
+		}
+		b.WriteString("\x1b[0m\r\n")
+	}
+	fmt.Fprintf(&b, "\x1b[K\x1b[7m%s\x1b[0m", status)
+	io.WriteString(os.Stdout, b.String())
+}
+
+// looks for query starting after the top line in direction dir and returns
+// the new top line. all lowercase queries match case-insensitively
+func (p *pager) search(query string, top, dir int) (int, string) {
+	if query == "" {
+		return top, ""
+	}
+	fold := strings.ToLower(query) == query
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := top + dir; i >= 0 && i < len(p.lines); i += dir {
+		line := highlight.StripANSI(p.lines[i])
+		if fold {
+			line = strings.ToLower(line)
+		}
+		if strings.Contains(line, query) {
+			return i, ""
+		}
+	}
+	return top, "pattern not found: " + query
+}
+
+// turns raw terminal input into key names: single characters as themselves,
+// control keys as ctrl-x and the usual escape sequences as up, pgdn etc
+func readKeys(tty *os.File) <-chan string {
+	keys := make(chan string)
+	go func() {
+		defer close(keys)
+		buf := make([]byte, 64)
+		for {
+			n, err := tty.Read(buf)
+			if err != nil {
+				return
+			}
+			for _, k := range parseKeys(buf[:n]) {
+				keys <- k
+			}
+		}
+	}()
+	return keys
+}
+
+var escapeKeys = map[string]string{
+	"\x1b[A": "up", "\x1b[B": "down",
+	"\x1bOA": "up", "\x1bOB": "down",
+	"\x1b[5~": "pgup", "\x1b[6~": "pgdn",
+	"\x1b[H": "home", "\x1b[F": "end",
+	"\x1b[1~": "home", "\x1b[4~": "end",
+}
+
+func parseKeys(b []byte) []string {
+	var keys []string
+	s := string(b)
+	for len(s) > 0 {
+		if s[0] == '\x1b' {
+			matched := false
+			for seq, name := range escapeKeys {
+				if strings.HasPrefix(s, seq) {
+					keys = append(keys, name)
+					s = s[len(seq):]
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				keys = append(keys, "esc")
+				s = s[1:]
+			}
+			continue
+		}
+
+		switch c := s[0]; {
+		case c == '\r' || c == '\n':
+			keys = append(keys, "enter")
+		case c == 0x7f || c == 0x08:
+			keys = append(keys, "backspace")
+		case c < 0x20:
+			keys = append(keys, "ctrl-"+string(rune(c+'a'-1)))
+		default:
+			r := []rune(s)[0]
+			keys = append(keys, string(r))
+			s = s[len(string(r)):]
+			continue
+		}
+		s = s[1:]
+	}
+	return keys
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+)
+
+const (
+	// the most a file is held in memory for, rendered. bigger files, and
+	// files whose output gets bigger than this, are streamed in turn like
+	// a single file would be
+	parallelMaxFile = 4 << 20
+	// how much highlighted output can be waiting to be printed
+	parallelBudget = 64 << 20
+)
+
+// prints files like catFile one after the other would, but highlights them
+// in a pool of --jobs workers. output still comes out in argument order:
+// each file is rendered into a buffer that's written once everything
+// before it has been, and so is what it had to say on errs. big files,
+// compressed ones, anything that isnt a regular file and anything that
+// renders too big are left for the writer to stream itself when it gets
+// to them
+func catFiles(files []string, out, errs io.Writer) {
+	jobs := flagJobs
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+	// numbers carried on from the file before cant be known ahead of it
+	numbered := (flagNumber || flagNumberNonblank) && !flagNumberPerFile
+	if jobs == 1 || len(files) < 2 || flagFollow || numbered {
+		for n, f := range files {
+			catFile(f, n, out, errs)
+		}
+		return
+	}
+
+	// nil for files the writer streams
+	results := make([]chan *rendered, len(files))
+	for n := range results {
+		results[n] = make(chan *rendered, 1)
+	}
+	budget := newByteBudget(parallelBudget)
+
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for range min(jobs, len(files)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range queue {
+				res := &rendered{}
+				catFile(files[n], n, renderedOut{res}, renderedErrs{res})
+				if res.spilled {
+					res.out, res.errs = bytes.Buffer{}, bytes.Buffer{}
+				}
+				res.held = int64(res.out.Len() + res.errs.Len())
+				budget.give(parallelMaxFile - res.held)
+				results[n] <- res
+			}
+		}()
+	}
+
+	// memory is taken in argument order, so whatever the writer is waiting
+	// on always has its share and the pool cant fill up with later files.
+	// highlighting makes a file many times bigger than it was, so each one
+	// takes the most it could hold and the worker gives back what it didnt
+	go func() {
+		defer close(queue)
+		for n, f := range files {
+			st, err := os.Stat(f)
+			if f == "-" || err != nil || !st.Mode().IsRegular() || st.Size() > parallelMaxFile ||
+				!flagNoDecompress && compressedFile(f) {
+				results[n] <- nil
+				continue
+			}
+			budget.take(parallelMaxFile)
+			queue <- n
+		}
+	}()
+
+	for n, f := range files {
+		res := <-results[n]
+		if res == nil || res.spilled {
+			catFile(f, n, out, errs)
+			continue
+		}
+		out.Write(res.out.Bytes())
+		errs.Write(res.errs.Bytes())
+		if res.failed {
+			failed.Store(true)
+		}
+		budget.give(res.held)
+	}
+	wg.Wait()
+}
+
+// a file a worker has rendered: its output, its messages, whether it
+// failed, and how much of the budget they take up. once they come to more
+// than parallelMaxFile the file is spilled: writes fail, so rendering
+// stops, and the writer prints the file itself
+type rendered struct {
+	out, errs bytes.Buffer
+	held      int64
+	failed    bool
+	spilled   bool
+}
+
+var errSpilled = errors.New("too big to hold, streamed instead")
+
+func (r *rendered) write(b *bytes.Buffer, p []byte) (int, error) {
+	if r.spilled || r.out.Len()+r.errs.Len()+len(p) > parallelMaxFile {
+		r.spilled = true
+		return 0, errSpilled
+	}
+	return b.Write(p)
+}
+
+type renderedOut struct{ *rendered }
+
+func (r renderedOut) Write(p []byte) (int, error) { return r.write(&r.out, p) }
+
+type renderedErrs struct{ *rendered }
+
+func (r renderedErrs) Write(p []byte) (int, error) { return r.write(&r.errs, p) }
+
+// a count of bytes that blocks whoever wants more than is left
+type byteBudget struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	left int64
+}
+
+func newByteBudget(n int64) *byteBudget {
+	b := &byteBudget{left: n}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// takes n, waiting until there's that much left
+func (b *byteBudget) take(n int64) {
+	b.mu.Lock()
+	for b.left < n {
+		b.cond.Wait()
+	}
+	b.left -= n
+	b.mu.Unlock()
+}
+
+func (b *byteBudget) give(n int64) {
+	b.mu.Lock()
+	b.left += n
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gcat/highlight"
+
+	"github.com/go-git/go-git/v6/plumbing/format/gitignore"
+)
+
+type pathGlob struct {
+	re       *regexp.Regexp
+	fullPath bool // has a slash, so it's matched against the path under the walked directory
+}
+
+func parsePathGlobs(flagName string, args []string) []pathGlob {
+	var globs []pathGlob
+	for _, a := range args {
+		re, err := highlight.GlobRegexp(strings.TrimSuffix(a, "/"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid --%s %q: %v\n", flagName, a, err)
+			os.Exit(1)
+		}
+		globs = append(globs, pathGlob{re, strings.Contains(strings.TrimSuffix(a, "/"), "/")})
+	}
+	return globs
+}
+
+// rel is slash separated and relative to the directory being walked
+func matchPathGlobs(globs []pathGlob, rel string) bool {
+	for _, g := range globs {
+		if g.fullPath && g.re.MatchString(rel) || !g.fullPath && g.re.MatchString(path.Base(rel)) {
+			return true
+		}
+	}
+	return false
+}
+
+// sizes like 512, 64K, 1M or 2GiB. 0 means no limit
+func parseSize(s string) (int64, error) {
+	num := strings.TrimRight(strings.ToUpper(s), "IB")
+	mult := int64(1)
+	if num != "" {
+		switch num[len(num)-1] {
+		case 'K':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		}
+		if mult > 1 {
+			num = num[:len(num)-1]
+		}
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("want a size like 512K or 1M")
+	}
+	return n * mult, nil
+}
+
+// the files to print for the operands, - (stdin) included. directories are
+// walked with -r, and patterns the shell didnt expand (quoted, or with ** in
+// them) are expanded here. anything else is left for catFile to open or
+// complain about
+func expandArgs(args []string) []string {
+	var files []string
+	for _, a := range args {
+		st, err := os.Stat(a)
+		switch {
+		case a == "-":
+			files = append(files, a)
+		case err == nil && st.IsDir() && flagRecursive:
+			files = append(files, walkDir(a)...)
+		case err != nil && strings.ContainsAny(a, "*?["):
+			matches := expandGlob(a)
+			if len(matches) == 0 {
+				files = append(files, a)
+			}
+			// what matched exists, so this only walks the directories
+			files = append(files, expandArgs(matches)...)
+		default:
+			files = append(files, a)
+		}
+	}
+	return files
+}
+
+// filepath.Glob does patterns the shell could have, ** and {a,b} need a
+// walk. that starts from the part of the pattern before the first wildcard
+// and leaves out directories the pattern cant reach into any more
+func expandGlob(pattern string) []string {
+	pattern = filepath.Clean(pattern)
+	if !strings.Contains(pattern, "**") && !strings.Contains(pattern, "{") {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid pattern %q: %v\n", pattern, err)
+		}
+		return matches
+	}
+	re, err := highlight.GlobRegexp(filepath.ToSlash(pattern))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid pattern %q: %v\n", pattern, err)
+		return nil
+	}
+
+	root := "."
+	elems := strings.Split(filepath.ToSlash(pattern), "/")
+	for i, e := range elems {
+		if strings.ContainsAny(e, "*?[{") {
+			if i > 0 {
+				root = strings.Join(elems[:i], "/")
+				if root == "" {
+					root = "/"
+				}
+			}
+			break
+		}
+	}
+	// nil for ** (anything goes below it) and for a brace split by a slash,
+	// which only the whole pattern can say anything about
+	elemRes := make([]*regexp.Regexp, len(elems))
+	for i, e := range elems {
+		if !strings.Contains(e, "**") {
+			elemRes[i], _ = highlight.GlobRegexp(e)
+		}
+	}
+	reachable := func(dir string) bool {
+		for i, part := range strings.Split(filepath.ToSlash(dir), "/") {
+			switch {
+			case i < len(elems) && strings.Contains(elems[i], "**"):
+				return true
+			case i >= len(elems)-1:
+				return false
+			case elemRes[i] != nil && !elemRes[i].MatchString(part):
+				return false
+			}
+		}
+		return true
+	}
+
+	var matches []string
+	filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if p != root && (d.Name() == ".git" || !reachable(p)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if re.MatchString(filepath.ToSlash(p)) {
+			matches = append(matches, p)
+		}
+		return nil
+	})
+	return matches
+}
+
+// every file under dir in lexical order, leaving out whatever .gitignore
+// files (from the repository root down) and --exclude leave out, anything
+// --include doesnt match when there are includes, and files over --max-size
+func walkDir(dir string) []string {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		operandError(os.Stderr, "cant walk %s: %v\n", dir, err)
+		return nil
+	}
+	repo := repoRoot(abs)
+
+	// ignore files above dir apply too, so git and cat agree on what's in it
+	var patterns []gitignore.Pattern
+	patterns = append(patterns, readIgnoreFile(filepath.Join(repo, ".git", "info", "exclude"), nil)...)
+	rel, _ := filepath.Rel(repo, abs)
+	var domain []string
+	for _, e := range splitPath(rel) {
+		patterns = append(patterns, readIgnoreFile(filepath.Join(repo, filepath.Join(domain...), ".gitignore"), domain)...)
+		domain = append(domain, e)
+	}
+
+	var files []string
+	filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			operandError(os.Stderr, "cant walk %s: %v\n", p, err)
+			return nil
+		}
+		pabs, _ := filepath.Abs(p)
+		relRepo, _ := filepath.Rel(repo, pabs)
+		elems := splitPath(relRepo)
+		relDir, _ := filepath.Rel(dir, p)
+		relDir = filepath.ToSlash(relDir)
+
+		if d.IsDir() {
+			if p == dir {
+				patterns = append(patterns, readIgnoreFile(filepath.Join(p, ".gitignore"), elems)...)
+				return nil
+			}
+			if d.Name() == ".git" || gitignore.NewMatcher(patterns).Match(elems, true) || matchPathGlobs(excludeGlobs, relDir) {
+				return filepath.SkipDir
+			}
+			patterns = append(patterns, readIgnoreFile(filepath.Join(p, ".gitignore"), elems)...)
+			return nil
+		}
+
+		if gitignore.NewMatcher(patterns).Match(elems, false) || matchPathGlobs(excludeGlobs, relDir) {
+			return nil
+		}
+		if len(includeGlobs) > 0 && !matchPathGlobs(includeGlobs, relDir) {
+			return nil
+		}
+		st, err := os.Stat(p)
+		if err != nil || !st.Mode().IsRegular() {
+			return nil
+		}
+		if maxSize > 0 && st.Size() > maxSize {
+			fmt.Fprintf(os.Stderr, "skipping %s: %s is over --max-size\n", p, highlight.HumanSize(st.Size()))
+			return nil
+		}
+		files = append(files, p)
+		return nil
+	})
+	return files
+}
+
+// the closest directory above dir with a .git in it, or dir itself
+func repoRoot(dir string) string {
+	for d := dir; ; {
+		if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+			return d
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			return dir
+		}
+		d = parent
+	}
+}
+
+func splitPath(rel string) []string {
+	if rel == "." || rel == "" {
+		return nil
+	}
+	return strings.Split(filepath.ToSlash(rel), "/")
+}
+
+func readIgnoreFile(name string, domain []string) []gitignore.Pattern {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil
+	}
+	var ps []gitignore.Pattern
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ps = append(ps, gitignore.ParsePattern(line, domain))
+	}
+	return ps
+}